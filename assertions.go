@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+)
+
+// Matcher describes the checks applied to a single gjson path in a step's
+// `expect` block. A plain scalar in YAML is shorthand for `equals`.
+type Matcher struct {
+	Equals    *string  `yaml:"equals"`
+	NotEquals *string  `yaml:"not_equals"`
+	Regex     string   `yaml:"regex"`
+	Exists    *bool    `yaml:"exists"`
+	Absent    *bool    `yaml:"absent"`
+	Contains  string   `yaml:"contains"`
+	GT        *float64 `yaml:"gt"`
+	LT        *float64 `yaml:"lt"`
+	Length    *int     `yaml:"length"`
+	Type      string   `yaml:"type"`
+	NotNull   *bool    `yaml:"not_null"`
+	Approx    *float64 `yaml:"approx"`
+	Tolerance *float64 `yaml:"tolerance"` // absolute, for approx; default defaultApproxTolerance
+}
+
+// defaultApproxTolerance is the absolute tolerance `approx` uses when the
+// matcher sets none.
+const defaultApproxTolerance = 1e-6
+
+func (m *Matcher) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		value := node.Value
+		m.Equals = &value
+		return nil
+	}
+
+	type rawMatcher Matcher
+	var raw rawMatcher
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	*m = Matcher(raw)
+	return nil
+}
+
+// expectationFailure is a single failed matcher, kept structured so the
+// console diff and the returned error stay in sync.
+type expectationFailure struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (f expectationFailure) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", f.Path, f.Expected, f.Actual)
+}
+
+func evaluateExpectations(payload []byte, expect map[string]Matcher, vars map[string]string) []expectationFailure {
+	if len(expect) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(expect))
+	for path := range expect {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var failures []expectationFailure
+	for _, path := range paths {
+		result := gjson.GetBytes(payload, render(path, vars))
+		failures = append(failures, expect[path].evaluate(path, result, vars)...)
+	}
+
+	return failures
+}
+
+func (m Matcher) evaluate(path string, result gjson.Result, vars map[string]string) []expectationFailure {
+	var failures []expectationFailure
+	fail := func(expected, actual string) {
+		failures = append(failures, expectationFailure{Path: path, Expected: expected, Actual: actual})
+	}
+
+	actual := describeResult(result)
+
+	if m.Exists != nil && *m.Exists != result.Exists() {
+		if *m.Exists {
+			fail("value to exist", "nothing")
+		} else {
+			fail("value to be absent", actual)
+		}
+	}
+
+	if m.Absent != nil && *m.Absent == result.Exists() {
+		if *m.Absent {
+			fail("value to be absent", actual)
+		} else {
+			fail("value to exist", "nothing")
+		}
+	}
+
+	if m.Equals != nil {
+		want := render(*m.Equals, vars)
+		if !result.Exists() || !resultEquals(result, want) {
+			fail(strconv.Quote(want), actual)
+		}
+	}
+
+	if m.NotEquals != nil {
+		want := render(*m.NotEquals, vars)
+		if result.Exists() && resultEquals(result, want) {
+			fail("value other than "+strconv.Quote(want), actual)
+		}
+	}
+
+	if m.Regex != "" {
+		pattern := render(m.Regex, vars)
+		re, err := regexp.Compile(pattern)
+		switch {
+		case err != nil:
+			fail("valid regex "+strconv.Quote(pattern), err.Error())
+		case !result.Exists() || !re.MatchString(result.String()):
+			fail("match for /"+pattern+"/", actual)
+		}
+	}
+
+	if m.Contains != "" {
+		want := render(m.Contains, vars)
+		if !resultContains(result, want) {
+			fail("value containing "+strconv.Quote(want), actual)
+		}
+	}
+
+	if m.GT != nil || m.LT != nil {
+		num, ok := resultNumber(result)
+		if m.GT != nil && (!ok || num <= *m.GT) {
+			fail("> "+formatFloat(*m.GT), actual)
+		}
+		if m.LT != nil && (!ok || num >= *m.LT) {
+			fail("< "+formatFloat(*m.LT), actual)
+		}
+	}
+
+	if m.Approx != nil {
+		tolerance := defaultApproxTolerance
+		if m.Tolerance != nil {
+			tolerance = *m.Tolerance
+		}
+		num, ok := resultNumber(result)
+		if !ok || math.Abs(num-*m.Approx) > tolerance {
+			fail(formatFloat(*m.Approx)+" ± "+formatFloat(tolerance), actual)
+		}
+	}
+
+	if m.NotNull != nil {
+		isNull := !result.Exists() || result.Type == gjson.Null
+		if *m.NotNull && isNull {
+			fail("non-null value", actual)
+		} else if !*m.NotNull && !isNull {
+			fail("null", actual)
+		}
+	}
+
+	if m.Length != nil {
+		length, ok := resultLength(result)
+		if !ok {
+			fail(fmt.Sprintf("length %d", *m.Length), actual)
+		} else if length != *m.Length {
+			fail(fmt.Sprintf("length %d", *m.Length), fmt.Sprintf("length %d", length))
+		}
+	}
+
+	if m.Type != "" {
+		want := normalizeResultType(m.Type)
+		if got := resultTypeName(result); got != want {
+			fail("type "+want, "type "+got)
+		}
+	}
+
+	return failures
+}
+
+func resultEquals(result gjson.Result, want string) bool {
+	if result.String() == want || result.Raw == want {
+		return true
+	}
+
+	if result.Type == gjson.Number {
+		if wantNum, err := strconv.ParseFloat(want, 64); err == nil {
+			return result.Float() == wantNum
+		}
+	}
+
+	return false
+}
+
+func resultContains(result gjson.Result, want string) bool {
+	if !result.Exists() {
+		return false
+	}
+
+	if result.IsArray() {
+		for _, item := range result.Array() {
+			if resultEquals(item, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return strings.Contains(result.String(), want)
+}
+
+func resultNumber(result gjson.Result) (float64, bool) {
+	switch result.Type {
+	case gjson.Number:
+		return result.Float(), true
+	case gjson.String:
+		num, err := strconv.ParseFloat(strings.TrimSpace(result.Str), 64)
+		return num, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func resultLength(result gjson.Result) (int, bool) {
+	switch {
+	case result.IsArray():
+		return len(result.Array()), true
+	case result.IsObject():
+		return len(result.Map()), true
+	case result.Type == gjson.String:
+		return len([]rune(result.Str)), true
+	default:
+		return 0, false
+	}
+}
+
+func resultTypeName(result gjson.Result) string {
+	switch {
+	case !result.Exists():
+		return "missing"
+	case result.IsArray():
+		return "array"
+	case result.IsObject():
+		return "object"
+	}
+
+	switch result.Type {
+	case gjson.String:
+		return "string"
+	case gjson.Number:
+		return "number"
+	case gjson.True, gjson.False:
+		return "bool"
+	default:
+		return "null"
+	}
+}
+
+func normalizeResultType(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "boolean":
+		return "bool"
+	case "int", "integer", "float":
+		return "number"
+	case "list":
+		return "array"
+	case "map":
+		return "object"
+	default:
+		return strings.ToLower(strings.TrimSpace(value))
+	}
+}
+
+func describeResult(result gjson.Result) string {
+	if !result.Exists() {
+		return "nothing"
+	}
+
+	return trimLongString(result.Raw)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (r *FlowRunner) validateExpectations(step Step, payload []byte, vars map[string]string, contextLabel string) error {
+	if len(step.Expect) == 0 {
+		return nil
+	}
+
+	if contextLabel == "" {
+		contextLabel = "response"
+	}
+
+	cleanPayload := bytes.TrimPrefix(payload, utf8BOM)
+	if !json.Valid(cleanPayload) {
+		return fmt.Errorf("step %q failed: expect requires a JSON %s", step.Name, contextLabel)
+	}
+
+	failures := evaluateExpectations(cleanPayload, step.Expect, vars)
+	if len(failures) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(r.output(), "%s✖ %s: %d expectation(s) failed%s\n", colorRed, step.Name, len(failures), colorReset)
+
+	lines := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		fmt.Fprintf(r.output(), "   %s- %s%s\n", colorRed, failure.String(), colorReset)
+		lines = append(lines, failure.String())
+	}
+
+	fmt.Fprintf(r.output(), "   %s%s: %s%s\n", colorGray, contextLabel, trimLongString(string(cleanPayload)), colorReset)
+
+	return fmt.Errorf("step %q failed: %s", step.Name, strings.Join(lines, "; "))
+}
+
+// validateNamedExpectations runs matchers keyed by a literal name (a cookie
+// or header) rather than a gjson path against a flat set of values.
+func (r *FlowRunner) validateNamedExpectations(step Step, expect map[string]Matcher, values, vars map[string]string, contextLabel string) error {
+	if len(expect) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("step %q: encode %s: %w", step.Name, contextLabel, err)
+	}
+
+	named := step
+	named.Expect = make(map[string]Matcher, len(expect))
+	for name, matcher := range expect {
+		named.Expect[gjson.Escape(name)] = matcher
+	}
+
+	return r.validateExpectations(named, payload, vars, contextLabel)
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	authTypeBearer            = "bearer"
+	authTypeBasic             = "basic"
+	authTypeClientCredentials = "client_credentials"
+	authTypePassword          = "password"
+
+	defaultAuthHeader = "Authorization"
+	// tokenExpirySkew refreshes tokens slightly early so a step never sends
+	// one that expires in flight.
+	tokenExpirySkew = 10 * time.Second
+)
+
+// AuthConfig is a flow-level credential injected into every HTTP request and
+// gRPC call. OAuth2 tokens are fetched once and cached on the runner.
+type AuthConfig struct {
+	Type         string   `yaml:"type"`          // bearer, basic, client_credentials, password
+	Token        string   `yaml:"token"`         // bearer
+	Username     string   `yaml:"username"`      // basic, password
+	Password     string   `yaml:"password"`      // basic, password
+	TokenURL     string   `yaml:"token_url"`     // client_credentials, password
+	ClientID     string   `yaml:"client_id"`     // client_credentials, password
+	ClientSecret string   `yaml:"client_secret"` // client_credentials, password
+	ClientAuth   string   `yaml:"client_auth"`   // body (default) or basic
+	Scopes       []string `yaml:"scopes"`
+	Audience     string   `yaml:"audience"`
+	Header       string   `yaml:"header"` // default Authorization
+}
+
+type oauthToken struct {
+	accessToken  string
+	tokenType    string
+	refreshToken string
+	expiresAt    time.Time
+}
+
+func (t *oauthToken) valid() bool {
+	return t != nil && (t.expiresAt.IsZero() || time.Now().Add(tokenExpirySkew).Before(t.expiresAt))
+}
+
+// tokenCache holds OAuth2 tokens per credential set, shared by every flow of
+// a run so parallel flows with the same auth block fetch one token.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]*tokenEntry
+}
+
+// tokenEntry serialises fetches for one credential set, so a slow token
+// endpoint only blocks flows that share those credentials.
+type tokenEntry struct {
+	mu    sync.Mutex
+	token *oauthToken
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{tokens: make(map[string]*tokenEntry)}
+}
+
+func (c *tokenCache) entry(key string) *tokenEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.tokens[key]
+	if !ok {
+		entry = &tokenEntry{}
+		c.tokens[key] = entry
+	}
+	return entry
+}
+
+// withAuth returns a copy of the runner that authenticates HTTP and gRPC
+// steps with the flow's auth block.
+func (r *FlowRunner) withAuth(auth *AuthConfig) *FlowRunner {
+	clone := *r
+	clone.auth = auth
+	return &clone
+}
+
+// authHeaderName returns the header the flow's auth is sent in, or "" when
+// the flow has no auth block.
+func (r *FlowRunner) authHeaderName(vars map[string]string) string {
+	if r.auth == nil {
+		return ""
+	}
+
+	if header := strings.TrimSpace(render(r.auth.Header, vars)); header != "" {
+		return header
+	}
+	return defaultAuthHeader
+}
+
+// authHeader returns the header name and value for the flow's auth, fetching
+// or refreshing an OAuth2 token when needed. It returns "" when the flow has
+// no auth block.
+func (r *FlowRunner) authHeader(ctx context.Context, vars map[string]string) (string, string, error) {
+	auth := r.auth
+	if auth == nil {
+		return "", "", nil
+	}
+
+	header := r.authHeaderName(vars)
+
+	switch strings.ToLower(strings.TrimSpace(auth.Type)) {
+	case authTypeBearer:
+		return header, "Bearer " + render(auth.Token, vars), nil
+	case authTypeBasic:
+		credentials := render(auth.Username, vars) + ":" + render(auth.Password, vars)
+		return header, "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)), nil
+	case authTypeClientCredentials, authTypePassword:
+		token, err := r.oauthToken(ctx, auth, vars)
+		if err != nil {
+			return "", "", err
+		}
+		return header, token.tokenType + " " + token.accessToken, nil
+	default:
+		return "", "", fmt.Errorf("unsupported auth type %q (use bearer, basic, client_credentials, or password)", auth.Type)
+	}
+}
+
+// refreshableAuth reports whether a 401 should invalidate the cached token
+// and retry the request once.
+func (r *FlowRunner) refreshableAuth() bool {
+	if r.auth == nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(r.auth.Type)) {
+	case authTypeClientCredentials, authTypePassword:
+		return true
+	}
+	return false
+}
+
+// invalidateAuth drops the cached token so the next authHeader call fetches
+// a new one. A refresh token, when present, is kept for that request.
+func (r *FlowRunner) invalidateAuth(vars map[string]string) {
+	if r.auth == nil || r.tokens == nil {
+		return
+	}
+
+	entry := r.tokens.entry(authCacheKey(r.auth, vars))
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.token != nil {
+		entry.token.expiresAt = time.Unix(1, 0)
+	}
+}
+
+func (r *FlowRunner) oauthToken(ctx context.Context, auth *AuthConfig, vars map[string]string) (*oauthToken, error) {
+	cache := r.tokens
+	if cache == nil {
+		cache = newTokenCache()
+	}
+
+	entry := cache.entry(authCacheKey(auth, vars))
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	cached := entry.token
+	if cached.valid() {
+		return cached, nil
+	}
+
+	var (
+		token *oauthToken
+		err   error
+	)
+	if cached != nil && cached.refreshToken != "" {
+		fmt.Fprintf(r.output(), "%s→ Refreshing OAuth2 token%s\n", colorGray, colorReset)
+		token, err = r.requestToken(ctx, auth, vars, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {cached.refreshToken},
+		})
+	}
+
+	// Fall back to the original grant when there is no refresh token or the
+	// server rejected it.
+	if token == nil {
+		form := url.Values{"grant_type": {strings.ToLower(strings.TrimSpace(auth.Type))}}
+		if form.Get("grant_type") == authTypePassword {
+			form.Set("username", render(auth.Username, vars))
+			form.Set("password", render(auth.Password, vars))
+		}
+
+		fmt.Fprintf(r.output(), "%s→ Fetching OAuth2 token (%s)%s\n", colorGray, form.Get("grant_type"), colorReset)
+		token, err = r.requestToken(ctx, auth, vars, form)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry.token = token
+	return token, nil
+}
+
+func (r *FlowRunner) requestToken(ctx context.Context, auth *AuthConfig, vars map[string]string, form url.Values) (*oauthToken, error) {
+	tokenURL := strings.TrimSpace(render(auth.TokenURL, vars))
+	if tokenURL == "" {
+		return nil, fmt.Errorf("auth type %q requires token_url", auth.Type)
+	}
+
+	if scopes := renderStringSlice(auth.Scopes, vars); len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+	if audience := strings.TrimSpace(render(auth.Audience, vars)); audience != "" {
+		form.Set("audience", audience)
+	}
+
+	clientID := render(auth.ClientID, vars)
+	clientSecret := render(auth.ClientSecret, vars)
+	useBasic := strings.EqualFold(strings.TrimSpace(auth.ClientAuth), authTypeBasic)
+	if !useBasic && clientID != "" {
+		form.Set("client_id", clientID)
+		if clientSecret != "" {
+			form.Set("client_secret", clientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", formContentType)
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	resp, err := r.tokenClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, trimLongString(string(body)))
+	}
+
+	var payload struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("parse token response: %w", err)
+	}
+	if payload.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+
+	token := &oauthToken{
+		accessToken:  payload.AccessToken,
+		tokenType:    "Bearer",
+		refreshToken: payload.RefreshToken,
+	}
+	if payload.TokenType != "" && !strings.EqualFold(payload.TokenType, authTypeBearer) {
+		token.tokenType = payload.TokenType
+	}
+	if payload.ExpiresIn > 0 {
+		token.expiresAt = time.Now().Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
+
+// tokenClient returns the client for token requests: the runner's transport
+// without the flow's cookie jar or step logging, so cookies set by the token
+// endpoint never reach flow requests.
+func (r *FlowRunner) tokenClient() *http.Client {
+	client := &http.Client{Timeout: httpClientTimeout}
+	if r.client != nil {
+		client.Transport = r.client.Transport
+		client.Timeout = r.client.Timeout
+	}
+	if logging, ok := client.Transport.(loggingTransport); ok {
+		client.Transport = logging.base
+	}
+	return client
+}
+
+// authCacheKey identifies a credential set. Secrets are included as digests
+// so blocks that differ only by secret never share a token.
+func authCacheKey(auth *AuthConfig, vars map[string]string) string {
+	return strings.Join([]string{
+		strings.ToLower(strings.TrimSpace(auth.Type)),
+		render(auth.TokenURL, vars),
+		render(auth.ClientID, vars),
+		secretDigest(render(auth.ClientSecret, vars)),
+		render(auth.Username, vars),
+		secretDigest(render(auth.Password, vars)),
+		strings.Join(renderStringSlice(auth.Scopes, vars), " "),
+		render(auth.Audience, vars),
+	}, "|")
+}
+
+func secretDigest(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// hasHeader reports whether a step sets a header itself, in which case the
+// flow's auth is not injected.
+func hasHeader(headers map[string]string, name string) bool {
+	for key := range headers {
+		if strings.EqualFold(strings.TrimSpace(key), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyHTTPAuth sets the flow's auth header on req unless the step sets that
+// header itself. It reports whether a header was injected.
+func (r *FlowRunner) applyHTTPAuth(ctx context.Context, req *http.Request, step Step, vars map[string]string) (bool, error) {
+	if r.auth == nil || hasHeader(step.Headers, r.authHeaderName(vars)) {
+		return false, nil
+	}
+
+	name, value, err := r.authHeader(ctx, vars)
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set(name, value)
+	return true, nil
+}
+
+// retryUnauthorized re-sends a request that got a 401 once with a freshly
+// fetched token, in case the cached one was revoked or expired early.
+func (r *FlowRunner) retryUnauthorized(ctx context.Context, client *http.Client, req *http.Request, step Step, vars map[string]string, resp *http.Response) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	fmt.Fprintf(r.output(), "%s↻ %s: 401, refreshing token and retrying%s\n", colorGray, step.Name, colorReset)
+	r.invalidateAuth(vars)
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	if _, err := r.applyHTTPAuth(ctx, retry, step, vars); err != nil {
+		return nil, err
+	}
+	if err := signRequest(retry, step.Sign, vars, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return client.Do(retry)
+}
+
+// grpcAuthMetadata returns the flow's auth as gRPC metadata ("key: value"),
+// unless the step already sets that key.
+func (r *FlowRunner) grpcAuthMetadata(ctx context.Context, cfg *GRPCStep, vars map[string]string) ([]string, error) {
+	if r.auth == nil || hasHeader(cfg.Metadata, r.authHeaderName(vars)) {
+		return nil, nil
+	}
+
+	name, value, err := r.authHeader(ctx, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{fmt.Sprintf("%s: %s", strings.ToLower(name), value)}, nil
+}
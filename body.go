@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// MultipartPart is one part of a multipart/form-data body: either a templated
+// text value or a file read from disk (relative to the flow file).
+type MultipartPart struct {
+	Name        string `yaml:"name"`
+	Value       string `yaml:"value"`
+	File        string `yaml:"file"`
+	Filename    string `yaml:"filename"`     // defaults to the file's base name
+	ContentType string `yaml:"content_type"` // defaults from the extension or content
+}
+
+// requestBody is a rendered HTTP body plus the summary recorded in the log.
+type requestBody struct {
+	reader      io.Reader
+	contentType string
+	logValue    any
+}
+
+// buildRequestBody renders whichever of body, form, multipart, or graphql a
+// step sets.
+func buildRequestBody(step Step, vars map[string]string) (requestBody, error) {
+	set := 0
+	for _, present := range []bool{step.Body != "", len(step.Form) > 0, len(step.Multipart) > 0, step.GraphQL != nil} {
+		if present {
+			set++
+		}
+	}
+	if set > 1 {
+		return requestBody{}, fmt.Errorf("step %q: body, form, multipart, and graphql are mutually exclusive", step.Name)
+	}
+
+	switch {
+	case step.GraphQL != nil:
+		return buildGraphQLBody(step, vars)
+	case len(step.Form) > 0:
+		return buildFormBody(step.Form, vars), nil
+	case len(step.Multipart) > 0:
+		body, err := buildMultipartBody(step.Multipart, step.dir, vars)
+		if err != nil {
+			return requestBody{}, fmt.Errorf("step %q: %w", step.Name, err)
+		}
+		return body, nil
+	}
+
+	bodyStr := render(step.Body, vars)
+
+	body := requestBody{logValue: normalizeJSONValue(bodyStr)}
+	if bodyStr != "" {
+		body.reader = bytes.NewBufferString(bodyStr)
+	}
+
+	return body, nil
+}
+
+func buildFormBody(fields map[string]string, vars map[string]string) requestBody {
+	values := make(url.Values, len(fields))
+	logged := make(map[string]string, len(fields))
+	for key, value := range fields {
+		rendered := render(value, vars)
+		values.Set(key, rendered)
+		logged[key] = rendered
+	}
+
+	return requestBody{
+		reader:      strings.NewReader(values.Encode()),
+		contentType: formContentType,
+		logValue:    logged,
+	}
+}
+
+// buildMultipartBody writes every part into memory. File contents are sent
+// but only their name, type, and size are logged.
+func buildMultipartBody(parts []MultipartPart, dir string, vars map[string]string) (requestBody, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	logged := make([]map[string]any, 0, len(parts))
+	for idx, part := range parts {
+		name := strings.TrimSpace(render(part.Name, vars))
+		if name == "" {
+			return requestBody{}, fmt.Errorf("multipart part %d requires a name", idx+1)
+		}
+
+		file := strings.TrimSpace(render(part.File, vars))
+		if file == "" {
+			value := render(part.Value, vars)
+			if err := writer.WriteField(name, value); err != nil {
+				return requestBody{}, fmt.Errorf("write multipart field %q: %w", name, err)
+			}
+			logged = append(logged, map[string]any{"name": name, "value": value})
+			continue
+		}
+
+		if part.Value != "" {
+			return requestBody{}, fmt.Errorf("multipart part %q: value and file are mutually exclusive", name)
+		}
+
+		path := resolveRelativePath(dir, file)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return requestBody{}, fmt.Errorf("read multipart file for part %q: %w", name, err)
+		}
+
+		filename := strings.TrimSpace(render(part.Filename, vars))
+		if filename == "" {
+			filename = filepath.Base(path)
+		}
+
+		contentType := strings.TrimSpace(render(part.ContentType, vars))
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(filename))
+		}
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+			"name":     name,
+			"filename": filename,
+		}))
+		header.Set("Content-Type", contentType)
+
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return requestBody{}, fmt.Errorf("create multipart part %q: %w", name, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return requestBody{}, fmt.Errorf("write multipart part %q: %w", name, err)
+		}
+
+		logged = append(logged, map[string]any{
+			"name":         name,
+			"filename":     filename,
+			"content_type": contentType,
+			"size":         len(data),
+		})
+	}
+
+	if err := writer.Close(); err != nil {
+		return requestBody{}, fmt.Errorf("close multipart body: %w", err)
+	}
+
+	return requestBody{
+		reader:      &buf,
+		contentType: writer.FormDataContentType(),
+		logValue:    map[string]any{"multipart": logged},
+	}, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// falsyConditionValues are the rendered `if` results that skip a step.
+var falsyConditionValues = map[string]bool{
+	"":           true,
+	"false":      true,
+	"0":          true,
+	"no":         true,
+	"off":        true,
+	"<no value>": true,
+}
+
+// evaluateCondition renders an `if` expression against vars and reports
+// whether it is truthy. Expressions without template delimiters are wrapped,
+// so `eq .env "staging"` and `{{eq .env "staging"}}` are equivalent.
+func evaluateCondition(expr string, vars map[string]string) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.Contains(expr, "{{") {
+		expr = "{{" + expr + "}}"
+	}
+
+	rendered, err := renderExact(expr, vars)
+	if err != nil {
+		return false, err
+	}
+
+	value := strings.ToLower(strings.TrimSpace(rendered))
+	return !falsyConditionValues[value], nil
+}
+
+// skipReason reports why a step should not run, or "" when it should. Static
+// `skip` wins over `if`.
+func (s Step) skipReason(vars map[string]string) (string, error) {
+	if s.Skip {
+		return "skip: true", nil
+	}
+
+	expr := strings.TrimSpace(s.If)
+	if expr == "" {
+		return "", nil
+	}
+
+	ok, err := evaluateCondition(expr, vars)
+	if err != nil {
+		return "", fmt.Errorf("step %q: if %q: %w", s.Name, expr, err)
+	}
+	if !ok {
+		return fmt.Sprintf("if %q is false", expr), nil
+	}
+
+	return "", nil
+}
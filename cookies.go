@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cookieJar wraps the standard jar and remembers every cookie it was given,
+// so a session can be written to --cookies-file and restored on the next run.
+type cookieJar struct {
+	jar *cookiejar.Jar
+
+	mu    sync.Mutex
+	saved map[string]savedCookie
+}
+
+type savedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+func newCookieJar() *cookieJar {
+	// cookiejar.New only fails on invalid options; nil options are valid.
+	jar, _ := cookiejar.New(nil)
+	return &cookieJar{jar: jar, saved: make(map[string]savedCookie)}
+}
+
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	origin := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+	for _, cookie := range cookies {
+		key := strings.Join([]string{u.Hostname(), cookie.Domain, cookie.Path, cookie.Name}, "|")
+
+		// Store absolute expiry so a restored cookie doesn't get a fresh Max-Age.
+		stored := *cookie
+		if stored.MaxAge > 0 {
+			stored.Expires = now.Add(time.Duration(stored.MaxAge) * time.Second)
+			stored.MaxAge = 0
+		}
+
+		if stored.MaxAge < 0 || (!stored.Expires.IsZero() && stored.Expires.Before(now)) {
+			delete(j.saved, key)
+			continue
+		}
+
+		j.saved[key] = savedCookie{URL: origin, Cookie: &stored}
+	}
+}
+
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// loadCookieJar restores a jar written by save. A missing file yields an
+// empty jar so the first run can create it.
+func loadCookieJar(path string) (*cookieJar, error) {
+	jar := newCookieJar()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return jar, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read cookies file: %w", err)
+	}
+
+	var entries []savedCookie
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse cookies file %q: %w", path, err)
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Cookie == nil || (!entry.Cookie.Expires.IsZero() && entry.Cookie.Expires.Before(now)) {
+			continue
+		}
+
+		u, err := url.Parse(entry.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parse cookies file %q: invalid url %q: %w", path, entry.URL, err)
+		}
+
+		jar.SetCookies(u, []*http.Cookie{entry.Cookie})
+	}
+
+	return jar, nil
+}
+
+func (j *cookieJar) save(path string) error {
+	j.mu.Lock()
+	entries := make([]savedCookie, 0, len(j.saved))
+	for _, entry := range j.saved {
+		entries = append(entries, entry)
+	}
+	j.mu.Unlock()
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode cookies: %w", err)
+	}
+
+	if err := ensureDirExists(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("create cookies directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, filePermission); err != nil {
+		return fmt.Errorf("write cookies file: %w", err)
+	}
+
+	return nil
+}
+
+// withCookies returns a copy of the runner whose HTTP client uses the
+// flow's cookie jar: the shared --cookies-file jar when set, otherwise a
+// fresh jar per flow. Flows can opt out with `cookies: false`.
+func (r *FlowRunner) withCookies(enabled *bool) *FlowRunner {
+	if r.client == nil {
+		return r
+	}
+
+	client := *r.client
+	switch {
+	case !boolValue(enabled, true):
+		client.Jar = nil
+	case r.cookies != nil:
+		client.Jar = r.cookies
+	default:
+		client.Jar = newCookieJar()
+	}
+
+	clone := *r
+	clone.client = &client
+	return &clone
+}
+
+// responseCookies lists the cookies that apply after a response: what the
+// jar holds for the final URL plus anything the response itself set.
+func responseCookies(jar http.CookieJar, resp *http.Response) map[string]string {
+	cookies := make(map[string]string)
+
+	if jar != nil && resp.Request != nil && resp.Request.URL != nil {
+		for _, cookie := range jar.Cookies(resp.Request.URL) {
+			cookies[cookie.Name] = cookie.Value
+		}
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(cookies, cookie.Name)
+			continue
+		}
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	return cookies
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// lastFileVar holds the path written by the most recent save_body_to.
+const lastFileVar = "last_file"
+
+// savedFile describes a response body streamed to disk by save_body_to.
+// expect_file matchers run against its JSON form.
+type savedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+}
+
+// writeResponseFile streams body to path, hashing it on the way so the file
+// is never held in memory.
+func writeResponseFile(body io.Reader, path string) (savedFile, error) {
+	if err := ensureDirExists(filepath.Dir(path)); err != nil {
+		return savedFile{}, fmt.Errorf("create directory: %w", err)
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePermission)
+	if err != nil {
+		return savedFile{}, err
+	}
+
+	sha := sha256.New()
+	sum := md5.New()
+	size, err := io.Copy(io.MultiWriter(out, sha, sum), body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return savedFile{}, err
+	}
+
+	return savedFile{
+		Path:   path,
+		Size:   size,
+		SHA256: hex.EncodeToString(sha.Sum(nil)),
+		MD5:    hex.EncodeToString(sum.Sum(nil)),
+	}, nil
+}
+
+// checkSaveBodyTo rejects options that need the response body in memory.
+func (s Step) checkSaveBodyTo() error {
+	if strings.TrimSpace(s.SaveBodyTo) == "" {
+		if len(s.ExpectFile) > 0 || strings.TrimSpace(s.Golden) != "" {
+			return fmt.Errorf("step %q: expect_file and golden require save_body_to", s.Name)
+		}
+		return nil
+	}
+
+	bodySaves, _ := splitHTTPSaves(s.Save)
+	switch {
+	case s.Stream != nil:
+		return fmt.Errorf("step %q: save_body_to cannot be combined with stream", s.Name)
+	case s.GraphQL != nil:
+		return fmt.Errorf("step %q: save_body_to cannot be combined with graphql", s.Name)
+	case len(bodySaves) > 0 || len(s.Expect) > 0:
+		return fmt.Errorf("step %q: save_body_to cannot be combined with body save paths or expect; use expect_file", s.Name)
+	}
+	return nil
+}
+
+func (r *FlowRunner) validateFileExpectations(step Step, file savedFile, vars map[string]string) error {
+	if len(step.ExpectFile) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("step %q: encode file: %w", step.Name, err)
+	}
+
+	fileStep := step
+	fileStep.Expect = step.ExpectFile
+	return r.validateExpectations(fileStep, payload, vars, "file")
+}
+
+// compareGolden checks path byte-for-byte against a fixture and reports the
+// first differing offset.
+func compareGolden(path, golden string) error {
+	want, err := os.Open(golden)
+	if err != nil {
+		return fmt.Errorf("open golden file: %w", err)
+	}
+	defer want.Close()
+
+	got, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer got.Close()
+
+	wantReader := bufio.NewReader(want)
+	gotReader := bufio.NewReader(got)
+	wantBuf := make([]byte, 32*1024)
+	gotBuf := make([]byte, 32*1024)
+
+	var offset int64
+	for {
+		wantN, wantErr := io.ReadFull(wantReader, wantBuf)
+		gotN, gotErr := io.ReadFull(gotReader, gotBuf)
+		if wantErr != nil && !isShortRead(wantErr) {
+			return fmt.Errorf("read golden file: %w", wantErr)
+		}
+		if gotErr != nil && !isShortRead(gotErr) {
+			return gotErr
+		}
+
+		if !bytes.Equal(wantBuf[:wantN], gotBuf[:gotN]) {
+			limit := min(wantN, gotN)
+			idx := 0
+			for idx < limit && wantBuf[idx] == gotBuf[idx] {
+				idx++
+			}
+			if idx == limit && wantN != gotN {
+				return fmt.Errorf("differs from golden %s: sizes differ after %d bytes", golden, offset+int64(limit))
+			}
+			return fmt.Errorf("differs from golden %s at byte %d", golden, offset+int64(idx))
+		}
+
+		offset += int64(wantN)
+		if wantErr != nil {
+			return nil
+		}
+	}
+}
+
+func isShortRead(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
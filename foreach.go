@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	foreachItemVar  = "item"
+	foreachIndexVar = "index"
+)
+
+// runForeachStep runs a step's nested steps once per element of a JSON
+// array, exposing the element as .item and its position as .index.
+func (r *FlowRunner) runForeachStep(ctx context.Context, step Step, vars map[string]string, result *flowResult) []error {
+	startedAt := time.Now()
+	record := func(status, reason string, err error) {
+		result.Steps = append(result.Steps, stepResult{
+			Name:       step.Name,
+			Type:       classifyStep(step),
+			Status:     status,
+			SkipReason: reason,
+			StartedAt:  startedAt,
+			Duration:   time.Since(startedAt),
+			Err:        err,
+		})
+	}
+
+	reason, err := step.skipReason(vars)
+	if err != nil {
+		record(stepStatusError, "", err)
+		return []error{err}
+	}
+	if reason != "" {
+		fmt.Fprintf(r.output(), "%s→ Skipping step %q (%s)%s\n", colorGray, step.Name, reason, colorReset)
+		record(stepStatusSkipped, reason, nil)
+		return nil
+	}
+
+	restore := applyStepParams(step, vars)
+	defer restore()
+
+	items, err := resolveForeachItems(step.Foreach, vars)
+	if err != nil {
+		err = fmt.Errorf("step %q: foreach: %w", step.Name, err)
+		record(stepStatusError, "", err)
+		return []error{err}
+	}
+
+	if len(items) == 0 {
+		fmt.Fprintf(r.output(), "%s→ No items for step %q%s\n", colorGray, step.Name, colorReset)
+		return nil
+	}
+
+	// Restore the enclosing loop's item/index so nested loops don't leak.
+	prevItem, hadItem := vars[foreachItemVar]
+	prevIndex, hadIndex := vars[foreachIndexVar]
+	defer func() {
+		restoreVar(vars, foreachItemVar, prevItem, hadItem)
+		restoreVar(vars, foreachIndexVar, prevIndex, hadIndex)
+	}()
+
+	var errs []error
+	for idx, item := range items {
+		fmt.Fprintf(r.output(), "%s→ %s [%d/%d]%s\n", colorCyan, step.Name, idx+1, len(items), colorReset)
+
+		vars[foreachItemVar] = item.String()
+		vars[foreachIndexVar] = strconv.Itoa(idx)
+
+		children := make([]Step, len(step.Steps))
+		for childIdx, child := range step.Steps {
+			child.Name = fmt.Sprintf("%s[%d]/%s", step.Name, idx, child.Name)
+			children[childIdx] = child
+		}
+
+		iterErrs := r.runStepList(ctx, children, vars, result)
+		errs = append(errs, iterErrs...)
+
+		if ctx.Err() != nil || (len(iterErrs) > 0 && !r.keepGoing && !step.ContinueOnError) {
+			break
+		}
+	}
+
+	return errs
+}
+
+// resolveForeachItems finds the array a foreach iterates over. The source is
+// rendered first; a JSON array is used as-is, a var name yields that var's
+// JSON, and `var.path` applies a gjson path to the var (e.g. `users.#.id`).
+func resolveForeachItems(source string, vars map[string]string) ([]gjson.Result, error) {
+	rendered := strings.TrimSpace(render(source, vars))
+	if rendered == "" {
+		return nil, fmt.Errorf("source %q is empty", source)
+	}
+
+	var value gjson.Result
+	switch {
+	case strings.HasPrefix(rendered, "["):
+		value = gjson.Parse(rendered)
+	case hasVar(vars, rendered):
+		value = gjson.Parse(vars[rendered])
+	default:
+		name, path, ok := strings.Cut(rendered, ".")
+		if !ok || !hasVar(vars, name) {
+			return nil, fmt.Errorf("var %q not found", name)
+		}
+		value = gjson.Get(vars[name], path)
+	}
+
+	if !value.IsArray() {
+		return nil, fmt.Errorf("%q is not a JSON array", rendered)
+	}
+
+	return value.Array(), nil
+}
+
+func hasVar(vars map[string]string, name string) bool {
+	_, ok := vars[name]
+	return ok
+}
+
+// setScopedVars sets values in vars and returns a func that restores what
+// those names held before (or removes them).
+func setScopedVars(vars, values map[string]string) func() {
+	type previous struct {
+		value   string
+		existed bool
+	}
+
+	saved := make(map[string]previous, len(values))
+	for name, value := range values {
+		old, ok := vars[name]
+		saved[name] = previous{value: old, existed: ok}
+		vars[name] = value
+	}
+
+	return func() {
+		for name, prev := range saved {
+			restoreVar(vars, name, prev.value, prev.existed)
+		}
+	}
+}
+
+func restoreVar(vars map[string]string, name, value string, existed bool) {
+	if existed {
+		vars[name] = value
+		return
+	}
+	delete(vars, name)
+}
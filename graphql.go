@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// GraphQLStep sends a GraphQL operation to the step's `url`. Save paths and
+// `expect` address the response `data` object directly.
+type GraphQLStep struct {
+	Query         string `yaml:"query"`
+	QueryFile     string `yaml:"query_file"` // .graphql file, relative to the flow file
+	Variables     any    `yaml:"variables"`  // map (string values templated) or a JSON string
+	OperationName string `yaml:"operation_name"`
+	AllowErrors   bool   `yaml:"allow_errors"` // don't fail on a non-empty `errors` array
+}
+
+// buildGraphQLBody renders the operation into the standard JSON request body.
+func buildGraphQLBody(step Step, vars map[string]string) (requestBody, error) {
+	cfg := step.GraphQL
+
+	query := render(cfg.Query, vars)
+	if file := strings.TrimSpace(render(cfg.QueryFile, vars)); file != "" {
+		if strings.TrimSpace(query) != "" {
+			return requestBody{}, fmt.Errorf("step %q: graphql query and query_file are mutually exclusive", step.Name)
+		}
+
+		data, err := os.ReadFile(resolveRelativePath(step.dir, file))
+		if err != nil {
+			return requestBody{}, fmt.Errorf("step %q: read graphql query file: %w", step.Name, err)
+		}
+		query = render(string(data), vars)
+	}
+
+	if strings.TrimSpace(query) == "" {
+		return requestBody{}, fmt.Errorf("step %q requires graphql.query or graphql.query_file", step.Name)
+	}
+
+	variables, err := renderGraphQLVariables(cfg.Variables, vars)
+	if err != nil {
+		return requestBody{}, fmt.Errorf("step %q: graphql variables: %w", step.Name, err)
+	}
+
+	payload := map[string]any{"query": query}
+	if variables != nil {
+		payload["variables"] = variables
+	}
+	if name := strings.TrimSpace(render(cfg.OperationName, vars)); name != "" {
+		payload["operationName"] = name
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return requestBody{}, fmt.Errorf("step %q: encode graphql request: %w", step.Name, err)
+	}
+
+	return requestBody{
+		reader:      bytes.NewReader(data),
+		contentType: "application/json",
+		logValue:    payload,
+	}, nil
+}
+
+// renderGraphQLVariables renders every string in a YAML variables value,
+// keeping numbers and booleans typed. A string value is rendered and parsed
+// as a JSON object instead.
+func renderGraphQLVariables(value any, vars map[string]string) (any, error) {
+	if raw, ok := value.(string); ok {
+		rendered := strings.TrimSpace(render(raw, vars))
+		if rendered == "" {
+			return nil, nil
+		}
+
+		var parsed map[string]any
+		if err := json.Unmarshal([]byte(rendered), &parsed); err != nil {
+			return nil, fmt.Errorf("parse JSON: %w", err)
+		}
+		return parsed, nil
+	}
+
+	return renderYAMLValue(value, vars), nil
+}
+
+func renderYAMLValue(value any, vars map[string]string) any {
+	switch v := value.(type) {
+	case string:
+		return render(v, vars)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = renderYAMLValue(item, vars)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for idx, item := range v {
+			out[idx] = renderYAMLValue(item, vars)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// graphQLData returns the `data` object of a GraphQL response, failing on a
+// non-empty `errors` array unless the step allows errors.
+func graphQLData(step Step, respBytes []byte) ([]byte, error) {
+	cleanPayload := bytes.TrimPrefix(respBytes, utf8BOM)
+	if !gjson.ValidBytes(cleanPayload) {
+		return nil, fmt.Errorf("step %q failed: graphql response is not JSON", step.Name)
+	}
+
+	errs := gjson.GetBytes(cleanPayload, "errors")
+	if !step.GraphQL.AllowErrors && errs.IsArray() && len(errs.Array()) > 0 {
+		messages := make([]string, 0, len(errs.Array()))
+		for _, item := range errs.Array() {
+			message := item.Get("message").String()
+			if message == "" {
+				message = item.Raw
+			}
+			messages = append(messages, message)
+		}
+		return nil, fmt.Errorf("step %q failed: graphql errors: %s", step.Name, strings.Join(messages, "; "))
+	}
+
+	data := gjson.GetBytes(cleanPayload, "data")
+	if !data.Exists() {
+		return []byte("null"), nil
+	}
+
+	return []byte(data.Raw), nil
+}
+
+// graphQLMethod defaults GraphQL steps to POST.
+func graphQLMethod(step Step) string {
+	if strings.TrimSpace(step.Method) == "" {
+		return http.MethodPost
+	}
+	return step.Method
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// HTTPTLSConfig configures TLS for an HTTP step. Certificate paths are
+// relative to the flow file.
+type HTTPTLSConfig struct {
+	CACert     string `yaml:"ca_cert"`
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	SkipVerify bool   `yaml:"skip_verify"`
+	ServerName string `yaml:"server_name"`
+}
+
+// transportOptions is the rendered form of a step's client options. It is
+// comparable so it can key the transport cache.
+type transportOptions struct {
+	host       string
+	caCert     string
+	clientCert string
+	clientKey  string
+	skipVerify bool
+	serverName string
+	proxy      string
+	http2      bool
+}
+
+// transportCache keeps one transport per host and option set, so steps with
+// the same TLS or proxy settings reuse connections for the whole run.
+type transportCache struct {
+	mu         sync.Mutex
+	transports map[transportOptions]*http.Transport
+}
+
+func newTransportCache() *transportCache {
+	return &transportCache{transports: make(map[transportOptions]*http.Transport)}
+}
+
+func (c *transportCache) get(opts transportOptions) (*http.Transport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if transport, ok := c.transports[opts]; ok {
+		return transport, nil
+	}
+
+	transport, err := newStepTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.transports[opts] = transport
+	return transport, nil
+}
+
+// closeIdle drops every cached transport, closing its idle keep-alive
+// connections.
+func (c *transportCache) closeIdle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for opts, transport := range c.transports {
+		transport.CloseIdleConnections()
+		delete(c.transports, opts)
+	}
+}
+
+// hasClientOptions reports whether a step needs something other than the
+// runner's default client.
+func (s Step) hasClientOptions() bool {
+	return s.TLS != nil || strings.TrimSpace(s.Proxy) != "" || s.HTTP2 || !boolValue(s.FollowRedirects, true)
+}
+
+// httpClient returns the client for an HTTP step: the runner's client, or a
+// copy of it using a cached transport built from the step's tls, proxy, and
+// http2 options. The copy keeps the flow's cookie jar.
+func (r *FlowRunner) httpClient(step Step, requestURL string, vars map[string]string) (*http.Client, error) {
+	if !step.hasClientOptions() {
+		return r.client, nil
+	}
+
+	base := r.client
+	if base == nil {
+		base = &http.Client{Timeout: httpClientTimeout}
+	}
+	client := *base
+
+	if step.TLS != nil || strings.TrimSpace(step.Proxy) != "" || step.HTTP2 {
+		transport, err := r.stepTransport(step, requestURL, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		client.Transport = transport
+		if r.captureSteps {
+			client.Transport = loggingTransport{base: transport}
+		}
+	}
+
+	if !boolValue(step.FollowRedirects, true) {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	return &client, nil
+}
+
+// stepTransport returns the cached transport for the step's tls, proxy, and
+// http2 options and the host of requestURL.
+func (r *FlowRunner) stepTransport(step Step, requestURL string, vars map[string]string) (*http.Transport, error) {
+	parsed, err := url.Parse(requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+
+	opts := transportOptions{
+		host:  parsed.Host,
+		proxy: strings.TrimSpace(render(step.Proxy, vars)),
+		http2: step.HTTP2,
+	}
+	if cfg := step.TLS; cfg != nil {
+		opts.caCert = resolveRelativePath(step.dir, render(cfg.CACert, vars))
+		opts.clientCert = resolveRelativePath(step.dir, render(cfg.ClientCert, vars))
+		opts.clientKey = resolveRelativePath(step.dir, render(cfg.ClientKey, vars))
+		opts.skipVerify = cfg.SkipVerify
+		opts.serverName = strings.TrimSpace(render(cfg.ServerName, vars))
+	}
+
+	cache := r.transports
+	if cache == nil {
+		cache = newTransportCache()
+	}
+
+	return cache.get(opts)
+}
+
+func newStepTransport(opts transportOptions) (*http.Transport, error) {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if base, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = base.Clone()
+	}
+
+	if opts.proxy != "" {
+		proxyURL, err := url.Parse(opts.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy %q: %w", opts.proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.skipVerify,
+		ServerName:         opts.serverName,
+	}
+
+	if opts.caCert != "" {
+		caBytes, err := os.ReadFile(opts.caCert)
+		if err != nil {
+			return nil, fmt.Errorf("read tls ca_cert %q: %w", opts.caCert, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("tls ca_cert %q contains no valid certificates", opts.caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.clientCert != "" || opts.clientKey != "" {
+		if opts.clientCert == "" || opts.clientKey == "" {
+			return nil, errors.New("tls client_cert and client_key must both be provided")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.clientCert, opts.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load tls client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	// http2 disables HTTP/1.1 fallback: TLS connections must negotiate h2
+	// and plain http:// URLs use h2c with prior knowledge.
+	if opts.http2 {
+		var protocols http.Protocols
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = &protocols
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fragment is a reusable list of steps referenced from a step via `use`.
+// Its vars act as default parameters that `with` can override.
+type Fragment struct {
+	Vars  map[string]string `yaml:"vars"`
+	Steps []Step            `yaml:"steps"`
+}
+
+// flowLoader reads a flow file, resolves its `include` entries, and expands
+// `use` steps into the fragment steps they reference.
+type flowLoader struct {
+	root      string
+	fragments map[string]Fragment
+	// fragmentFiles records the file each named fragment came from, so a
+	// file loaded twice (included and also used) does not clash with itself.
+	fragmentFiles map[string]string
+	fileFragments map[string]Fragment
+	included      map[string]bool
+	loading       []string
+}
+
+func loadFlowFile(path string) (Flow, error) {
+	loader := &flowLoader{
+		root:          path,
+		fragments:     make(map[string]Fragment),
+		fragmentFiles: make(map[string]string),
+		fileFragments: make(map[string]Fragment),
+		included:      make(map[string]bool),
+	}
+
+	flow, err := loader.load(path)
+	if err != nil {
+		return Flow{}, err
+	}
+
+	for _, list := range []*[]Step{&flow.Setup, &flow.Steps, &flow.Teardown} {
+		steps, err := loader.expandSteps(*list, nil)
+		if err != nil {
+			return Flow{}, err
+		}
+		*list = steps
+	}
+
+	return flow, nil
+}
+
+func (l *flowLoader) load(path string) (Flow, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	if slices.Contains(l.loading, abs) {
+		return Flow{}, fmt.Errorf("include cycle: %s", strings.Join(append(l.loading, abs), " -> "))
+	}
+	l.loading = append(l.loading, abs)
+	defer func() {
+		l.loading = l.loading[:len(l.loading)-1]
+	}()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if path != l.root {
+			return Flow{}, fmt.Errorf("read included file %q: %w", path, err)
+		}
+		return Flow{}, fmt.Errorf("read flow file: %w", err)
+	}
+
+	var flow Flow
+	if err := yaml.Unmarshal(data, &flow); err != nil {
+		if path != l.root {
+			return Flow{}, fmt.Errorf("parse included file %q: %w", path, err)
+		}
+		return Flow{}, fmt.Errorf("parse flow file: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	merged := Flow{Vars: make(map[string]string), Matrix: flow.Matrix, Cookies: flow.Cookies}
+
+	// Included files contribute vars, setup, and steps ahead of the including
+	// file, so the including file's own vars win; their teardown runs after
+	// the including file's. Each file is included at most once.
+	for _, include := range flow.Include {
+		includePath := resolveRelativePath(dir, include)
+		if includePath == "" {
+			continue
+		}
+
+		includeAbs, err := filepath.Abs(includePath)
+		if err != nil {
+			includeAbs = includePath
+		}
+		if l.included[includeAbs] {
+			continue
+		}
+		l.included[includeAbs] = true
+
+		included, err := l.load(includePath)
+		if err != nil {
+			return Flow{}, err
+		}
+
+		maps.Copy(merged.Vars, included.Vars)
+		if included.Auth != nil {
+			merged.Auth = included.Auth
+		}
+		merged.Setup = append(merged.Setup, included.Setup...)
+		merged.Steps = append(merged.Steps, included.Steps...)
+		merged.Teardown = append(included.Teardown, merged.Teardown...)
+	}
+
+	maps.Copy(merged.Vars, flow.Vars)
+	if flow.Auth != nil {
+		merged.Auth = flow.Auth
+	}
+
+	for name, fragment := range flow.Fragments {
+		if source, exists := l.fragmentFiles[name]; exists {
+			if source == abs {
+				continue
+			}
+			return Flow{}, fmt.Errorf("fragment %q defined more than once (last in %q)", name, path)
+		}
+		fragment.Steps = withStepDir(fragment.Steps, dir)
+		l.fragments[name] = fragment
+		l.fragmentFiles[name] = abs
+	}
+
+	merged.Setup = append(merged.Setup, withStepDir(flow.Setup, dir)...)
+	merged.Steps = append(merged.Steps, withStepDir(flow.Steps, dir)...)
+	merged.Teardown = append(withStepDir(flow.Teardown, dir), merged.Teardown...)
+
+	return merged, nil
+}
+
+func (l *flowLoader) expandSteps(steps []Step, stack []string) ([]Step, error) {
+	expanded := make([]Step, 0, len(steps))
+
+	for _, step := range steps {
+		use := strings.TrimSpace(step.Use)
+		if use == "" {
+			if len(step.Steps) > 0 {
+				children, err := l.expandSteps(step.Steps, stack)
+				if err != nil {
+					return nil, err
+				}
+				step.Steps = children
+			}
+			expanded = append(expanded, step)
+			continue
+		}
+
+		if stepType := classifyStep(step); stepType != "step" {
+			return nil, fmt.Errorf("step %q: use cannot be combined with a %s step", step.Name, stepType)
+		}
+
+		fragment, key, err := l.fragment(use, step.dir)
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %w", step.Name, err)
+		}
+
+		if slices.Contains(stack, key) {
+			return nil, fmt.Errorf("step %q: fragment cycle: %s", step.Name, strings.Join(append(stack, key), " -> "))
+		}
+
+		children, err := l.expandSteps(fragment.Steps, append(stack, key))
+		if err != nil {
+			return nil, err
+		}
+
+		label := step.Name
+		if label == "" {
+			label = use
+		}
+
+		params := make(map[string]string, len(fragment.Vars)+len(step.With))
+		maps.Copy(params, fragment.Vars)
+		maps.Copy(params, step.With)
+
+		for idx := range children {
+			children[idx].Name = label + "/" + children[idx].Name
+			if step.ContinueOnError {
+				children[idx].ContinueOnError = true
+			}
+		}
+
+		// The use step becomes a group: its skip/if is evaluated once, and its
+		// params are set only while the fragment's steps run.
+		expanded = append(expanded, Step{
+			Name:            label,
+			Skip:            step.Skip,
+			If:              step.If,
+			ContinueOnError: step.ContinueOnError,
+			With:            params,
+			Steps:           children,
+			dir:             step.dir,
+			fragment:        key,
+		})
+	}
+
+	return expanded, nil
+}
+
+// fragment resolves a `use` reference: named fragments declared in the flow
+// or its includes take precedence, otherwise the name is treated as a YAML
+// file path (extension optional) relative to the referencing file.
+func (l *flowLoader) fragment(name, dir string) (Fragment, string, error) {
+	if fragment, ok := l.fragments[name]; ok {
+		return fragment, name, nil
+	}
+
+	base := resolveRelativePath(dir, name)
+	for _, candidate := range []string{base, base + ".yaml", base + ".yml"} {
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		abs, err := filepath.Abs(candidate)
+		if err != nil {
+			abs = candidate
+		}
+
+		if fragment, ok := l.fileFragments[abs]; ok {
+			return fragment, abs, nil
+		}
+
+		loaded, err := l.load(candidate)
+		if err != nil {
+			return Fragment{}, "", err
+		}
+		if len(loaded.Setup) > 0 || len(loaded.Teardown) > 0 {
+			return Fragment{}, "", fmt.Errorf("fragment file %q cannot declare setup or teardown; include it instead", candidate)
+		}
+
+		fragment := Fragment{Vars: loaded.Vars, Steps: loaded.Steps}
+		l.fileFragments[abs] = fragment
+		return fragment, abs, nil
+	}
+
+	return Fragment{}, "", fmt.Errorf("fragment %q not found", name)
+}
+
+func resolveRelativePath(dir, path string) string {
+	trimmed := strings.TrimSpace(path)
+	if trimmed == "" || filepath.IsAbs(trimmed) {
+		return trimmed
+	}
+	return filepath.Join(dir, trimmed)
+}
+
+func withStepDir(steps []Step, dir string) []Step {
+	out := make([]Step, len(steps))
+	for idx, step := range steps {
+		step.dir = dir
+		step.Steps = withStepDir(step.Steps, dir)
+		out[idx] = step
+	}
+	return out
+}
+
+// applyStepParams renders a step's `with` values against the current vars and
+// sets them. The returned func restores the previous values, so params are
+// only visible to the step (or fragment) they were passed to.
+func applyStepParams(step Step, vars map[string]string) func() {
+	if len(step.With) == 0 {
+		return func() {}
+	}
+
+	rendered := make(map[string]string, len(step.With))
+	for key, value := range step.With {
+		rendered[key] = render(value, vars)
+	}
+
+	return setScopedVars(vars, rendered)
+}
+
+// runFragmentStep runs the steps a `use` step expanded into, with the use
+// step's params set for their duration. A skipped use step records every
+// fragment step as skipped without touching vars.
+func (r *FlowRunner) runFragmentStep(ctx context.Context, step Step, vars map[string]string, result *flowResult) []error {
+	reason, err := step.skipReason(vars)
+	if err != nil {
+		result.Steps = append(result.Steps, stepResult{
+			Name:      step.Name,
+			Type:      classifyStep(step),
+			Status:    stepStatusError,
+			StartedAt: time.Now(),
+			Err:       err,
+		})
+		return []error{err}
+	}
+	if reason != "" {
+		r.recordSkipped(step.Steps, reason, result)
+		return nil
+	}
+
+	restore := applyStepParams(step, vars)
+	defer restore()
+
+	return r.runStepList(ctx, step.Steps, vars, result)
+}
+
+// recordSkipped reports each step, including those of nested fragments, as
+// skipped for reason.
+func (r *FlowRunner) recordSkipped(steps []Step, reason string, result *flowResult) {
+	for _, step := range steps {
+		if step.fragment != "" {
+			r.recordSkipped(step.Steps, reason, result)
+			continue
+		}
+
+		fmt.Fprintf(r.output(), "%s→ Skipping step %q (%s)%s\n", colorGray, step.Name, reason, colorReset)
+		skipped := stepResult{
+			Name:       step.Name,
+			Type:       classifyStep(step),
+			Status:     stepStatusSkipped,
+			SkipReason: reason,
+			StartedAt:  time.Now(),
+		}
+		result.Steps = append(result.Steps, skipped)
+		if r.logger != nil {
+			r.logger.Record(skipped.logEntry())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// waitSkipper lets Ctrl-C cut a `wait` short instead of cancelling the run.
+type waitSkipper struct {
+	mu    sync.Mutex
+	waits map[chan struct{}]struct{}
+}
+
+func newWaitSkipper() *waitSkipper {
+	return &waitSkipper{waits: make(map[chan struct{}]struct{})}
+}
+
+// register returns a channel that is closed when the wait should end early,
+// and a func to call once the wait is over.
+func (w *waitSkipper) register() (<-chan struct{}, func()) {
+	if w == nil {
+		return nil, func() {}
+	}
+
+	skip := make(chan struct{})
+	w.mu.Lock()
+	w.waits[skip] = struct{}{}
+	w.mu.Unlock()
+
+	return skip, func() {
+		w.mu.Lock()
+		delete(w.waits, skip)
+		w.mu.Unlock()
+	}
+}
+
+// skip ends every wait in progress and reports whether there was one.
+func (w *waitSkipper) skip() bool {
+	if w == nil {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	skipped := len(w.waits) > 0
+	for skip := range w.waits {
+		close(skip)
+		delete(w.waits, skip)
+	}
+	return skipped
+}
+
+// handleInterrupts returns a context for the run. Ctrl-C during a `wait`
+// only skips the wait, as it always has. Otherwise it cancels the context so
+// the running steps stop and teardown can clean up, and the next Ctrl-C
+// falls back to the default behaviour and exits immediately.
+func handleInterrupts(parent context.Context, waits *waitSkipper) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+				if waits.skip() {
+					continue
+				}
+				cancel()
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ctx, cancel
+}
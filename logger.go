@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -18,6 +19,22 @@ import (
 type stepLogContext struct {
 	Request  map[string]any
 	Response map[string]any
+
+	// sensitive lists step-specific header names, such as a signature
+	// header, whose values are redacted like sensitiveHeaders.
+	sensitive []string
+}
+
+// redactedValue replaces credentials in logged headers and gRPC metadata.
+// Logs and reports are often published as CI artifacts.
+const redactedValue = "[redacted]"
+
+// sensitiveHeaders are never logged. X-Amz-* headers are redacted as well.
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
 }
 
 type logContextKey struct{}
@@ -26,6 +43,7 @@ type stepLogEntry struct {
 	Step           string         `json:"step"`
 	Type           string         `json:"type"`
 	Status         string         `json:"status"`
+	Reason         string         `json:"reason,omitempty"`
 	StartedAt      time.Time      `json:"started_at"`
 	DurationMillis int64          `json:"duration_ms"`
 	Request        map[string]any `json:"request,omitempty"`
@@ -34,6 +52,7 @@ type stepLogEntry struct {
 }
 
 type runLogger struct {
+	mu        sync.Mutex
 	dir       string
 	runID     string
 	startedAt time.Time
@@ -58,11 +77,22 @@ func (l *runLogger) Record(entry stepLogEntry) {
 	if l == nil {
 		return
 	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	l.entries = append(l.entries, entry)
 }
 
 func (l *runLogger) Close() error {
-	if l == nil || len(l.entries) == 0 {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) == 0 {
 		return nil
 	}
 
@@ -118,6 +148,68 @@ func buildLogHTML(runID string, jsonData string) string {
 	return buf.String()
 }
 
+// addSensitiveHeaders marks more header names for redaction in this step's
+// log.
+func (c *stepLogContext) addSensitiveHeaders(names ...string) {
+	if c == nil {
+		return
+	}
+	for _, name := range names {
+		if strings.TrimSpace(name) != "" {
+			c.sensitive = append(c.sensitive, name)
+		}
+	}
+}
+
+func (c *stepLogContext) isSensitiveHeader(name string) bool {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	if sensitiveHeaders[lower] || strings.HasPrefix(lower, "x-amz-") {
+		return true
+	}
+
+	if c == nil {
+		return false
+	}
+	for _, extra := range c.sensitive {
+		if strings.EqualFold(strings.TrimSpace(extra), lower) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders returns a copy of headers with sensitive values replaced.
+func (c *stepLogContext) redactHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+
+	out := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if c.isSensitiveHeader(name) {
+			value = redactedValue
+		}
+		out[name] = value
+	}
+	return out
+}
+
+// redactMetadata does the same for gRPC "key: value" metadata entries.
+func (c *stepLogContext) redactMetadata(entries []string) []string {
+	if len(entries) == 0 {
+		return entries
+	}
+
+	out := make([]string, len(entries))
+	for idx, entry := range entries {
+		if key, _, ok := strings.Cut(entry, ":"); ok && c.isSensitiveHeader(key) {
+			entry = key + ": " + redactedValue
+		}
+		out[idx] = entry
+	}
+	return out
+}
+
 func (c *stepLogContext) ensureRequestMap() map[string]any {
 	if c == nil {
 		return nil
@@ -139,7 +231,7 @@ func (c *stepLogContext) ensureResponseMap() map[string]any {
 }
 
 func (r *FlowRunner) newStepLogContext() *stepLogContext {
-	if r == nil || r.logger == nil {
+	if r == nil || (r.logger == nil && !r.captureSteps) {
 		return nil
 	}
 	return &stepLogContext{}
@@ -147,12 +239,18 @@ func (r *FlowRunner) newStepLogContext() *stepLogContext {
 
 func classifyStep(step Step) string {
 	switch {
+	case strings.TrimSpace(step.Foreach) != "":
+		return "foreach"
 	case strings.TrimSpace(step.SQL) != "":
 		return "sql"
 	case step.Mongo != nil:
 		return "mongo"
 	case step.GRPC != nil:
 		return "grpc"
+	case step.GraphQL != nil:
+		return "graphql"
+	case step.WebSocket != nil:
+		return "websocket"
 	case step.Method != "" && step.URL != "":
 		return "http"
 	default:
@@ -217,7 +315,7 @@ func (c *stepLogContext) recordHTTPMetrics(req *http.Request, resp *http.Respons
 		if req.URL != nil && reqMap["url"] == nil {
 			reqMap["url"] = req.URL.String()
 		}
-		reqMap["headers"] = flattenHTTPHeader(req.Header)
+		reqMap["headers"] = c.redactHeaders(flattenHTTPHeader(req.Header))
 		reqMap["sent_at"] = startedAt.UTC().Format(time.RFC3339Nano)
 	}
 
@@ -226,7 +324,7 @@ func (c *stepLogContext) recordHTTPMetrics(req *http.Request, resp *http.Respons
 
 	if resp != nil {
 		respMap["status"] = resp.StatusCode
-		respMap["headers"] = flattenHTTPHeader(resp.Header)
+		respMap["headers"] = c.redactHeaders(flattenHTTPHeader(resp.Header))
 	}
 
 	if reqErr != nil {
@@ -14,18 +14,19 @@ import (
 	"maps"
 	"net/http"
 	"os"
-	"os/signal"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
 	legacyproto "github.com/golang/protobuf/proto"
 	"github.com/tidwall/gjson"
 	"github.com/urfave/cli/v2"
-	"gopkg.in/yaml.v3"
 
 	"github.com/fullstorydev/grpcurl"
 	"github.com/jhump/protoreflect/desc"
@@ -39,8 +40,6 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-
-	_ "github.com/lib/pq"
 )
 
 const (
@@ -69,27 +68,66 @@ const (
 var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
 
 type Flow struct {
-	Vars  map[string]string `yaml:"vars"`
-	Steps []Step            `yaml:"steps"`
+	Include   []string            `yaml:"include"`
+	Vars      map[string]string   `yaml:"vars"`
+	Fragments map[string]Fragment `yaml:"fragments"`
+	Matrix    *Matrix             `yaml:"matrix"`  // run the flow once per row
+	Cookies   *bool               `yaml:"cookies"` // cookie jar for HTTP steps (default true)
+	Auth      *AuthConfig         `yaml:"auth"`    // credentials for every HTTP and gRPC step
+	Setup     []Step              `yaml:"setup"`
+	Steps     []Step              `yaml:"steps"`
+	Teardown  []Step              `yaml:"teardown"` // always runs, even after failures
 }
 
 type Step struct {
-	Wait               string            `yaml:"wait"`
-	Skip               bool              `yaml:"skip"`
-	Export             *bool             `yaml:"export"`
-	Name               string            `yaml:"name"`
-	TimeoutSeconds     int               `yaml:"timeout_seconds"`
-	Method             string            `yaml:"method"`
-	URL                string            `yaml:"url"`
-	Headers            map[string]string `yaml:"headers"`
-	Body               string            `yaml:"body"`
-	ExpectStatus       int               `yaml:"expect_status"`
-	Save               map[string]string `yaml:"save"` // key -> gjson path
-	SQL                string            `yaml:"sql"`
-	DatabaseURL        string            `yaml:"database_url"`
-	ExpectAffectedRows int               `yaml:"expect_affected_rows"`
-	Mongo              *MongoStep        `yaml:"mongo"`
-	GRPC               *GRPCStep         `yaml:"grpc"`
+	Wait               string             `yaml:"wait"`
+	Skip               bool               `yaml:"skip"`
+	If                 string             `yaml:"if"` // run only when the expression is truthy
+	ContinueOnError    bool               `yaml:"continue_on_error"`
+	Export             *bool              `yaml:"export"`
+	Name               string             `yaml:"name"`
+	TimeoutSeconds     int                `yaml:"timeout_seconds"`
+	Method             string             `yaml:"method"`
+	URL                string             `yaml:"url"`
+	Headers            map[string]string  `yaml:"headers"`
+	Body               string             `yaml:"body"`
+	Form               map[string]string  `yaml:"form"`      // urlencoded fields
+	Multipart          []MultipartPart    `yaml:"multipart"` // multipart/form-data parts
+	Sign               *SignConfig        `yaml:"sign"`      // HMAC or SigV4, applied last
+	TLS                *HTTPTLSConfig     `yaml:"tls"`
+	Proxy              string             `yaml:"proxy"`
+	FollowRedirects    *bool              `yaml:"follow_redirects"` // default true
+	HTTP2              bool               `yaml:"http2"`            // require HTTP/2
+	Stream             *StreamConfig      `yaml:"stream"`           // read SSE or line events incrementally
+	SaveBodyTo         string             `yaml:"save_body_to"`     // stream the response body to this file
+	ExpectFile         map[string]Matcher `yaml:"expect_file"`      // size/sha256/md5 of the saved file
+	Golden             string             `yaml:"golden"`           // fixture the saved file must match
+	ExpectStatus       int                `yaml:"expect_status"`
+	Save               map[string]string  `yaml:"save"`           // key -> gjson path
+	Expect             map[string]Matcher `yaml:"expect"`         // gjson path -> matcher
+	ExpectHeaders      map[string]Matcher `yaml:"expect_headers"` // header name -> matcher
+	ExpectCookies      map[string]Matcher `yaml:"expect_cookies"` // cookie name -> matcher
+	SQL                string             `yaml:"sql"`
+	Params             []any              `yaml:"params"` // bind arguments for the sql placeholders
+	Driver             string             `yaml:"driver"` // postgres, mysql, sqlite, sqlserver; default from database_url
+	DatabaseURL        string             `yaml:"database_url"`
+	ExpectAffectedRows int                `yaml:"expect_affected_rows"`
+	SaveRows           string             `yaml:"save_rows"`     // var receiving every row as a JSON array
+	ExpectRows         *int               `yaml:"expect_rows"`   // exact row count; 0 is allowed
+	ExpectResult       *ResultExpectation `yaml:"expect_result"` // inline table of expected rows
+	Retry              *RetryPolicy       `yaml:"retry"`
+	Matrix             *Matrix            `yaml:"matrix"`  // run the step once per row
+	Foreach            string             `yaml:"foreach"` // JSON array source for nested steps
+	Steps              []Step             `yaml:"steps"`   // run once per foreach item
+	Mongo              *MongoStep         `yaml:"mongo"`
+	GRPC               *GRPCStep          `yaml:"grpc"`
+	GraphQL            *GraphQLStep       `yaml:"graphql"`
+	WebSocket          *WebSocketStep     `yaml:"websocket"`
+	Use                string             `yaml:"use"`  // fragment name or file
+	With               map[string]string  `yaml:"with"` // vars set before the step runs
+
+	dir      string // directory of the file that declared the step
+	fragment string // set on the group a `use` step expands into
 }
 
 type MongoStep struct {
@@ -131,9 +169,26 @@ type FlowFile struct {
 }
 
 type FlowRunner struct {
-	client   *http.Client
-	exporter *varExporter
-	logger   *runLogger
+	client    *http.Client
+	exporter  *varExporter
+	logger    *runLogger
+	keepGoing bool
+	out       io.Writer
+	// captureSteps keeps request/response details for logs and reports.
+	captureSteps bool
+	// cookies is shared by every flow when --cookies-file is set.
+	cookies     *cookieJar
+	cookiesFile string
+	// auth is the current flow's auth block; tokens caches OAuth2 tokens
+	// across flows.
+	auth   *AuthConfig
+	tokens *tokenCache
+	// transports holds the per-host transports of steps with client options.
+	transports *transportCache
+	// conns keeps database and gRPC connections open across steps.
+	conns *connCache
+	// waits lets Ctrl-C skip the `wait` steps in progress.
+	waits *waitSkipper
 }
 
 type exportRecord struct {
@@ -142,6 +197,7 @@ type exportRecord struct {
 }
 
 type varExporter struct {
+	mu      sync.Mutex
 	path    string
 	records []exportRecord
 }
@@ -169,13 +225,13 @@ func ensureDirExists(dir string) error {
 	return os.MkdirAll(cleaned, dirPermission)
 }
 
-func newHTTPClient(logger *runLogger) *http.Client {
+func newHTTPClient(captureRequests bool) *http.Client {
 	var transport http.RoundTripper = http.DefaultTransport
 	if base, ok := http.DefaultTransport.(*http.Transport); ok {
 		transport = base.Clone()
 	}
 
-	if logger != nil {
+	if captureRequests {
 		transport = loggingTransport{base: transport}
 	}
 
@@ -185,7 +241,7 @@ func newHTTPClient(logger *runLogger) *http.Client {
 	}
 }
 
-func newFlowRunner(exportPath, logDir string) (*FlowRunner, error) {
+func newFlowRunner(exportPath, logDir string, captureSteps bool) (*FlowRunner, error) {
 	exporter, err := newVarExporter(exportPath)
 	if err != nil {
 		return nil, err
@@ -196,13 +252,43 @@ func newFlowRunner(exportPath, logDir string) (*FlowRunner, error) {
 		return nil, err
 	}
 
+	captureSteps = captureSteps || logger != nil
+
 	return &FlowRunner{
-		client:   newHTTPClient(logger),
-		exporter: exporter,
-		logger:   logger,
+		client:       newHTTPClient(captureSteps),
+		exporter:     exporter,
+		logger:       logger,
+		captureSteps: captureSteps,
+		tokens:       newTokenCache(),
+		transports:   newTransportCache(),
+		conns:        newConnCache(),
+		waits:        newWaitSkipper(),
 	}, nil
 }
 
+// output returns the writer step progress is printed to. Flows running in
+// parallel each get their own buffer so their lines do not interleave.
+func (r *FlowRunner) output() io.Writer {
+	if r == nil || r.out == nil {
+		return os.Stdout
+	}
+	return r.out
+}
+
+// withOutput returns a shallow copy of the runner that prints to w while
+// sharing the HTTP client, exporter, and logger.
+func (r *FlowRunner) withOutput(w io.Writer) *FlowRunner {
+	clone := *r
+	clone.out = w
+	return &clone
+}
+
+func (r *FlowRunner) withKeepGoing(keepGoing bool) *FlowRunner {
+	clone := *r
+	clone.keepGoing = keepGoing
+	return &clone
+}
+
 func (r *FlowRunner) Close() error {
 	if r == nil {
 		return nil
@@ -220,6 +306,22 @@ func (r *FlowRunner) Close() error {
 		}
 	}
 
+	if r.cookies != nil && r.cookiesFile != "" {
+		if cookieErr := r.cookies.save(r.cookiesFile); err == nil {
+			err = cookieErr
+		}
+	}
+
+	if r.conns != nil {
+		if connErr := r.conns.close(); err == nil {
+			err = connErr
+		}
+	}
+
+	if r.transports != nil {
+		r.transports.closeIdle()
+	}
+
 	return err
 }
 
@@ -238,6 +340,9 @@ func (e *varExporter) Record(stepName string, values map[string]any) {
 	exportVars := make(map[string]any, len(values))
 	maps.Copy(exportVars, values)
 
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	e.records = append(e.records, exportRecord{
 		Step: stepName,
 		Vars: exportVars,
@@ -245,7 +350,14 @@ func (e *varExporter) Record(stepName string, values map[string]any) {
 }
 
 func (e *varExporter) Close() error {
-	if e == nil || len(e.records) == 0 {
+	if e == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.records) == 0 {
 		return nil
 	}
 
@@ -468,6 +580,26 @@ steps:
 						Aliases: []string{"l"},
 						Usage:   "Directory to store per-step logs (HTML + JSON). Disabled when empty.",
 					},
+					&cli.BoolFlag{
+						Name:    "keep-going",
+						Aliases: []string{"k"},
+						Usage:   "Continue with remaining steps and flows after a failure; exit non-zero at the end if anything failed",
+					},
+					&cli.IntFlag{
+						Name:    "parallel",
+						Aliases: []string{"p"},
+						Value:   1,
+						Usage:   "Number of flows to run concurrently (each flow keeps its own vars and buffered output)",
+					},
+					&cli.StringFlag{
+						Name:  "cookies-file",
+						Usage: "Load HTTP cookies from this JSON file before the run and save them back afterwards (shared by all flows)",
+					},
+					&cli.StringSliceFlag{
+						Name:    "report",
+						Aliases: []string{"r"},
+						Usage:   "Write a test report (format junit=path or json=path). Can be provided multiple times",
+					},
 				},
 				Action: runFlowsAction,
 			},
@@ -541,7 +673,12 @@ func runFlowsAction(c *cli.Context) (err error) {
 
 	logDir := c.String("log")
 
-	runner, err := newFlowRunner(exportFile, logDir)
+	reports, err := parseReportSpecs(c.StringSlice("report"))
+	if err != nil {
+		return err
+	}
+
+	runner, err := newFlowRunner(exportFile, logDir, len(reports) > 0)
 	if err != nil {
 		return err
 	}
@@ -552,19 +689,117 @@ func runFlowsAction(c *cli.Context) (err error) {
 		}
 	}()
 
-	for idx, target := range targets {
-		if idx > 0 {
-			fmt.Println()
-		}
+	runner.keepGoing = c.Bool("keep-going")
 
-		fmt.Printf("%s=== Flow: %s (%s) ===%s\n", bold+colorCyan, target.Name, target.Path, colorReset)
-
-		if err := runner.RunFlow(c.Context, target.Path, overrideVars); err != nil {
+	if cookiesFile := c.String("cookies-file"); cookiesFile != "" {
+		jar, err := loadCookieJar(cookiesFile)
+		if err != nil {
 			return err
 		}
+		runner.cookies = jar
+		runner.cookiesFile = cookiesFile
 	}
 
-	return nil
+	ctx, cancel := handleInterrupts(c.Context, runner.waits)
+	defer cancel()
+
+	results := runner.runTargets(ctx, targets, overrideVars, c.Int("parallel"))
+
+	fmt.Println()
+	printRunSummary(os.Stdout, results)
+
+	if err := writeReports(reports, results); err != nil {
+		return err
+	}
+
+	return summarizeFailures(results)
+}
+
+// runTargets executes flows with up to `parallel` workers. Serial runs stream
+// output directly; parallel runs buffer each flow and print it on completion.
+// Unless keepGoing is set, a failure stops new flows from starting and they
+// are reported as skipped.
+func (r *FlowRunner) runTargets(ctx context.Context, targets []FlowFile, overrides map[string]string, parallel int) []flowResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	perTarget := make([][]flowResult, len(targets))
+	for idx, target := range targets {
+		perTarget[idx] = []flowResult{{Name: target.Name, Path: target.Path, Status: flowStatusSkip}}
+	}
+
+	var (
+		stopped atomic.Bool
+		outMu   sync.Mutex
+		wg      sync.WaitGroup
+	)
+
+	jobs := make(chan int)
+
+	for range min(parallel, len(targets)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := range jobs {
+				if stopped.Load() || ctx.Err() != nil {
+					continue
+				}
+
+				target := targets[idx]
+				header := fmt.Sprintf("%s=== Flow: %s (%s) ===%s\n", bold+colorCyan, target.Name, target.Path, colorReset)
+
+				flowRunner := r
+				var buf *bytes.Buffer
+				if parallel > 1 {
+					buf = &bytes.Buffer{}
+					buf.WriteString(header)
+					flowRunner = r.withOutput(buf)
+				} else {
+					if idx > 0 {
+						fmt.Fprintln(r.output())
+					}
+					fmt.Fprint(r.output(), header)
+				}
+
+				flowResults := flowRunner.runFlow(ctx, target.Path, overrides)
+				failed := false
+				for i := range flowResults {
+					flowResults[i].Name = target.Name
+					if flowResults[i].Iteration != "" {
+						flowResults[i].Name = matrixName(target.Name, flowResults[i].Iteration)
+					}
+					failed = failed || flowResults[i].Err != nil
+				}
+				perTarget[idx] = flowResults
+
+				if buf != nil {
+					outMu.Lock()
+					fmt.Fprintln(r.output())
+					_, _ = buf.WriteTo(r.output())
+					outMu.Unlock()
+				}
+
+				if failed && !r.keepGoing {
+					stopped.Store(true)
+				}
+			}
+		}()
+	}
+
+	for idx := range targets {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	var results []flowResult
+	for _, flowResults := range perTarget {
+		results = append(results, flowResults...)
+	}
+
+	return results
 }
 
 func resolveFlowTargets(filePath, dir, flow string) ([]FlowFile, error) {
@@ -648,32 +883,203 @@ func listFlows(dir string) ([]FlowFile, error) {
 }
 
 func (r *FlowRunner) RunFlow(ctx context.Context, flowPath string, overrides map[string]string) error {
-	data, err := os.ReadFile(flowPath)
+	var errs []error
+	for _, result := range r.runFlow(ctx, flowPath, overrides) {
+		errs = append(errs, result.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// runFlow loads a flow and runs it once, or once per row when it declares a
+// matrix. Each iteration gets its own result so reports list them separately.
+func (r *FlowRunner) runFlow(ctx context.Context, flowPath string, overrides map[string]string) []flowResult {
+	startedAt := time.Now()
+	failed := func(err error) []flowResult {
+		return []flowResult{{
+			Path:      flowPath,
+			Status:    flowStatusFail,
+			StartedAt: startedAt,
+			Duration:  time.Since(startedAt),
+			Err:       err,
+		}}
+	}
+
+	flow, err := loadFlowFile(flowPath)
 	if err != nil {
-		return fmt.Errorf("read flow file: %w", err)
+		return failed(err)
 	}
 
-	var flow Flow
-	if err := yaml.Unmarshal(data, &flow); err != nil {
-		return fmt.Errorf("parse flow file: %w", err)
+	if flow.Matrix == nil {
+		return []flowResult{r.runFlowIteration(ctx, flow, flowPath, matrixRow{}, overrides)}
 	}
 
+	// The matrix file path may use vars, including --var overrides.
+	matrixVars := maps.Clone(flow.Vars)
+	if matrixVars == nil {
+		matrixVars = make(map[string]string, len(overrides))
+	}
+	maps.Copy(matrixVars, overrides)
+
+	rows, err := flow.Matrix.load(filepath.Dir(flowPath), matrixVars)
+	if err != nil {
+		return failed(fmt.Errorf("load flow matrix: %w", err))
+	}
+
+	results := make([]flowResult, 0, len(rows))
+	stopped := false
+	for _, row := range rows {
+		if stopped || ctx.Err() != nil {
+			results = append(results, flowResult{Path: flowPath, Iteration: row.Label, Status: flowStatusSkip})
+			continue
+		}
+
+		fmt.Fprintf(r.output(), "%s--- Matrix: %s ---%s\n", colorCyan, row.Label, colorReset)
+
+		result := r.runFlowIteration(ctx, flow, flowPath, row, overrides)
+		results = append(results, result)
+
+		if result.Err != nil && !r.keepGoing {
+			stopped = true
+		}
+	}
+
+	return results
+}
+
+func (r *FlowRunner) runFlowIteration(ctx context.Context, flow Flow, flowPath string, row matrixRow, overrides map[string]string) (result flowResult) {
+	startedAt := time.Now()
+	result.Path = flowPath
+	result.Iteration = row.Label
+	result.StartedAt = startedAt
+
+	defer func() {
+		result.Duration = time.Since(startedAt)
+		result.Status = result.flowStatus()
+	}()
+
 	vars := map[string]string{}
 	if flow.Vars != nil {
 		maps.Copy(vars, flow.Vars)
 	}
 
+	maps.Copy(vars, row.Vars)
 	maps.Insert(vars, maps.All(overrides))
 
-	for _, step := range flow.Steps {
-		if err := r.executeStep(ctx, step, vars); err != nil {
-			return err
+	runner := r.withCookies(flow.Cookies).withAuth(flow.Auth)
+
+	// Setup failures skip the main steps; teardown always runs, even after a
+	// failure or Ctrl-C, and sees every var saved up to that point.
+	stepErrs := runner.runStepList(ctx, flow.Setup, vars, &result)
+	if len(stepErrs) == 0 {
+		stepErrs = runner.runStepList(ctx, flow.Steps, vars, &result)
+	} else {
+		runner.recordSkipped(flow.Steps, "setup failed", &result)
+	}
+
+	if len(flow.Teardown) > 0 {
+		fmt.Fprintf(runner.output(), "%s→ Running teardown%s\n", colorGray, colorReset)
+		teardown := runner.withKeepGoing(true)
+		stepErrs = append(stepErrs, teardown.runStepList(context.WithoutCancel(ctx), flow.Teardown, vars, &result)...)
+	}
+
+	result.Err = errors.Join(stepErrs...)
+	return result
+}
+
+// runStepList executes steps in order, appending their results. It stops at
+// the first failure unless keepGoing or the failing step's continue_on_error
+// is set, and always once ctx is cancelled.
+func (r *FlowRunner) runStepList(ctx context.Context, steps []Step, vars map[string]string, result *flowResult) []error {
+	var errs []error
+	for _, step := range steps {
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("flow interrupted before step %q: %w", step.Name, ctx.Err()))
+			break
 		}
+
+		var stepErrs []error
+		if step.Matrix != nil {
+			stepErrs = r.runMatrixStep(ctx, step, vars, result)
+		} else {
+			stepErrs = r.runStepEntry(ctx, step, vars, result)
+		}
+
+		if len(stepErrs) == 0 {
+			continue
+		}
+
+		errs = append(errs, stepErrs...)
+		if !r.keepGoing && !step.ContinueOnError {
+			break
+		}
+	}
+
+	return errs
+}
+
+// runStepEntry runs a single step, or a foreach step's nested steps, and
+// records the results.
+func (r *FlowRunner) runStepEntry(ctx context.Context, step Step, vars map[string]string, result *flowResult) []error {
+	if step.fragment != "" {
+		return r.runFragmentStep(ctx, step, vars, result)
+	}
+	if strings.TrimSpace(step.Foreach) != "" {
+		return r.runForeachStep(ctx, step, vars, result)
+	}
+
+	stepRes := r.runStep(ctx, step, vars)
+	result.Steps = append(result.Steps, stepRes)
+	if stepRes.Err != nil {
+		return []error{stepRes.Err}
 	}
 
 	return nil
 }
 
+// runMatrixStep runs a step once per matrix row with the row's columns merged
+// into vars, recording every iteration as its own step result. Columns are
+// restored after each row, so they never leak into other rows or later steps.
+func (r *FlowRunner) runMatrixStep(ctx context.Context, step Step, vars map[string]string, result *flowResult) []error {
+	rows, err := step.Matrix.load(step.dir, vars)
+	if err != nil {
+		err = fmt.Errorf("step %q: load matrix: %w", step.Name, err)
+		result.Steps = append(result.Steps, stepResult{
+			Name:      step.Name,
+			Type:      classifyStep(step),
+			Status:    stepStatusError,
+			StartedAt: time.Now(),
+			Err:       err,
+		})
+		return []error{err}
+	}
+
+	var errs []error
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("flow interrupted before step %q: %w", matrixName(step.Name, row.Label), ctx.Err()))
+			break
+		}
+
+		iteration := step
+		iteration.Matrix = nil
+		iteration.Name = matrixName(step.Name, row.Label)
+
+		restore := setScopedVars(vars, row.Vars)
+		iterErrs := r.runStepEntry(ctx, iteration, vars, result)
+		restore()
+		if len(iterErrs) == 0 {
+			continue
+		}
+
+		errs = append(errs, iterErrs...)
+		if !r.keepGoing && !step.ContinueOnError {
+			break
+		}
+	}
+
+	return errs
+}
+
 func parseVarOverrides(pairs []string) (map[string]string, error) {
 	if len(pairs) == 0 {
 		return nil, nil
@@ -704,128 +1110,170 @@ func parseVarOverrides(pairs []string) (map[string]string, error) {
 	return overrides, nil
 }
 
-func (r *FlowRunner) executeStep(ctx context.Context, step Step, vars map[string]string) (err error) {
+func (r *FlowRunner) executeStep(ctx context.Context, step Step, vars map[string]string) error {
+	return r.runStep(ctx, step, vars).Err
+}
+
+// runStep executes a step, records it in the run log, and returns its
+// result with the captured request/response for summaries and reports.
+func (r *FlowRunner) runStep(ctx context.Context, step Step, vars map[string]string) stepResult {
 	logCtx := r.newStepLogContext()
-	stepType := classifyStep(step)
-	logStatus := "success"
 	startedAt := time.Now()
 
-	defer func() {
-		if r.logger == nil {
-			return
-		}
+	status, skipReason, err := r.executeStepBody(ctx, step, vars, logCtx)
+	if status != stepStatusSkipped && err != nil {
+		status = stepStatusError
+	}
 
-		status := logStatus
-		if status != "skipped" && err != nil {
-			status = "error"
-		}
+	result := stepResult{
+		Name:       step.Name,
+		Type:       classifyStep(step),
+		Status:     status,
+		SkipReason: skipReason,
+		StartedAt:  startedAt,
+		Duration:   time.Since(startedAt),
+		Err:        err,
+	}
 
-		var req, resp map[string]any
-		if logCtx != nil {
-			req = logCtx.Request
-			resp = logCtx.Response
-		}
+	if logCtx != nil {
+		result.Request = logCtx.Request
+		result.Response = logCtx.Response
+	}
 
-		var errMsg string
-		if err != nil {
-			errMsg = err.Error()
-		}
-
-		r.logger.Record(stepLogEntry{
-			Step:           step.Name,
-			Type:           stepType,
-			Status:         status,
-			StartedAt:      startedAt.UTC(),
-			DurationMillis: time.Since(startedAt).Milliseconds(),
-			Request:        req,
-			Response:       resp,
-			Error:          errMsg,
-		})
-	}()
+	if r.logger != nil {
+		r.logger.Record(result.logEntry())
+	}
 
-	if step.Skip {
-		fmt.Printf("%s→ Skipping step %q%s\n", colorGray, step.Name, colorReset)
-		logStatus = "skipped"
-		return nil
+	return result
+}
+
+// executeStepBody handles skip/wait/retry around a step and reports whether
+// it ran ("success") or was skipped, and if so why.
+func (r *FlowRunner) executeStepBody(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext) (string, string, error) {
+	status := stepStatusSuccess
+
+	reason, err := step.skipReason(vars)
+	if err != nil {
+		return status, "", err
+	}
+	if reason != "" {
+		fmt.Fprintf(r.output(), "%s→ Skipping step %q (%s)%s\n", colorGray, step.Name, reason, colorReset)
+		return stepStatusSkipped, reason, nil
 	}
 
+	restore := applyStepParams(step, vars)
+	defer restore()
+
 	if step.Wait != "" {
 		timeToWait, err := time.ParseDuration(render(step.Wait, vars))
 		if err != nil {
-			return fmt.Errorf("parse wait duration for step %q: %w", step.Name, err)
+			return status, "", fmt.Errorf("parse wait duration for step %q: %w", step.Name, err)
 		}
 
-		fmt.Printf("%s→ Waiting %s before step %q%s\n", colorGray, timeToWait.String(), step.Name, colorReset)
+		fmt.Fprintf(r.output(), "%s→ Waiting %s before step %q%s\n", colorGray, timeToWait.String(), step.Name, colorReset)
 
 		ticker := time.NewTicker(1 * time.Second)
 		defer ticker.Stop()
 
-		signalChan := make(chan os.Signal, 1)
-		signal.Notify(signalChan, os.Interrupt)
+		timer := time.NewTimer(timeToWait)
+		defer timer.Stop()
 
-		done := make(chan struct{})
-		go func() {
-			time.Sleep(timeToWait)
-			close(done)
-		}()
+		skip, done := r.waits.register()
+		defer done()
 
 		remaining := timeToWait
 		moveOn := false
 		for !moveOn {
 			select {
-			case <-done:
-				fmt.Printf("%s→ Wait complete for step %q%s\n", colorGray, step.Name, colorReset)
+			case <-timer.C:
+				fmt.Fprintf(r.output(), "%s→ Wait complete for step %q%s\n", colorGray, step.Name, colorReset)
 				moveOn = true
 			case <-ticker.C:
 				remaining -= 1 * time.Second
 				if remaining < 0 {
 					remaining = 0
 				}
-				fmt.Printf(" %s→ Waiting... %s remaining for step %q%s\r", colorGray, remaining.String(), step.Name, colorReset)
-			case <-signalChan:
-				fmt.Printf("\n%s→ Wait interrupted for step %q%s\n", colorGray, step.Name, colorReset)
-				close(done)
+				fmt.Fprintf(r.output(), " %s→ Waiting... %s remaining for step %q%s\r", colorGray, remaining.String(), step.Name, colorReset)
+			case <-skip:
+				fmt.Fprintf(r.output(), "\n%s→ Wait interrupted for step %q%s\n", colorGray, step.Name, colorReset)
+				moveOn = true
+			case <-ctx.Done():
+				fmt.Fprintf(r.output(), "\n%s→ Wait cancelled for step %q%s\n", colorGray, step.Name, colorReset)
+				return status, "", fmt.Errorf("wait for step %q: %w", step.Name, ctx.Err())
 			}
 		}
 	}
 
+	if step.Retry != nil {
+		return status, "", r.executeWithRetry(ctx, step, vars, logCtx)
+	}
+
+	return status, "", r.executeStepAttempt(ctx, step, vars, logCtx, nil)
+}
+
+// executeStepAttempt runs a single attempt of a step. When outcome is non-nil
+// it is filled with the raw result so retry conditions can inspect it.
+func (r *FlowRunner) executeStepAttempt(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext, outcome *stepOutcome) error {
 	sqlStmt := strings.TrimSpace(render(step.SQL, vars))
 	if sqlStmt != "" {
 		step.applyDefaults()
-		stepType = "sql"
-		return executeSQLStep(ctx, step, sqlStmt, vars, logCtx)
+		return r.executeSQLStep(ctx, step, sqlStmt, vars, logCtx, outcome)
 	}
 
 	if step.Mongo != nil {
 		step.applyDefaults()
-		stepType = "mongo"
-		return r.executeMongoStep(ctx, step, vars, logCtx)
+		return r.executeMongoStep(ctx, step, vars, logCtx, outcome)
 	}
 
 	if step.GRPC != nil {
 		step.applyDefaults()
-		stepType = "grpc"
-		return r.executeGRPCStep(ctx, step, vars, logCtx)
+		return r.executeGRPCStep(ctx, step, vars, logCtx, outcome)
+	}
+
+	if step.WebSocket != nil {
+		step.applyDefaults()
+		return r.executeWebSocketStep(ctx, step, vars, logCtx, outcome)
+	}
+
+	if step.GraphQL != nil {
+		step.Method = graphQLMethod(step)
 	}
 
 	if step.Method == "" || step.URL == "" {
 		return fmt.Errorf("step %q requires sql, grpc, or method/url fields", step.Name)
 	}
-	stepType = "http"
+
+	return r.executeHTTPStep(ctx, step, vars, logCtx, outcome)
+}
+
+func (r *FlowRunner) executeHTTPStep(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext, outcome *stepOutcome) error {
+	if err := step.checkSaveBodyTo(); err != nil {
+		return err
+	}
 
 	url := render(step.URL, vars)
-	bodyStr := render(step.Body, vars)
 
-	var body io.Reader
-	if bodyStr != "" {
-		body = bytes.NewBufferString(bodyStr)
+	body, err := buildRequestBody(step, vars)
+	if err != nil {
+		return err
 	}
 
 	if logCtx != nil {
 		reqMap := logCtx.ensureRequestMap()
 		reqMap["method"] = step.Method
 		reqMap["url"] = url
-		reqMap["body"] = normalizeJSONValue(bodyStr)
+		reqMap["body"] = body.logValue
+	}
+
+	var streamDuration time.Duration
+	if step.Stream != nil {
+		if streamDuration, err = step.Stream.duration(vars); err != nil {
+			return fmt.Errorf("step %q: %w", step.Name, err)
+		}
+		// Give a long stream room to finish before the step times out.
+		if step.TimeoutSeconds == 0 && streamDuration > 0 {
+			step.TimeoutSeconds = int(streamDuration/time.Second) + defaultStepTimeoutSeconds
+		}
 	}
 
 	step.applyDefaults()
@@ -833,11 +1281,20 @@ func (r *FlowRunner) executeStep(ctx context.Context, step Step, vars map[string
 	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(step.TimeoutSeconds)*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(stepCtx, step.Method, url, body)
+	req, err := http.NewRequestWithContext(stepCtx, step.Method, url, body.reader)
 	if err != nil {
 		return fmt.Errorf("build request for step %q: %w", step.Name, err)
 	}
 
+	client, err := r.httpClient(step, url, vars)
+	if err != nil {
+		return fmt.Errorf("configure client for step %q: %w", step.Name, err)
+	}
+
+	if body.contentType != "" {
+		req.Header.Set("Content-Type", body.contentType)
+	}
+
 	var headerSnapshot map[string]string
 	if logCtx != nil && len(step.Headers) > 0 {
 		headerSnapshot = make(map[string]string, len(step.Headers))
@@ -851,14 +1308,30 @@ func (r *FlowRunner) executeStep(ctx context.Context, step Step, vars map[string
 		}
 	}
 
+	if step.Stream != nil && req.Header.Get("Accept") == "" {
+		if format, _ := step.Stream.format(); format == streamFormatSSE {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+	}
+
+	authInjected, err := r.applyHTTPAuth(stepCtx, req, step, vars)
+	if err != nil {
+		return fmt.Errorf("authenticate step %q: %w", step.Name, err)
+	}
+
+	if err := signRequest(req, step.Sign, vars, time.Now()); err != nil {
+		return fmt.Errorf("sign request for step %q: %w", step.Name, err)
+	}
+
 	if logCtx != nil {
 		req = req.WithContext(context.WithValue(req.Context(), logContextKey{}, logCtx))
+		logCtx.addSensitiveHeaders(signatureHeader(step.Sign, vars), r.authHeaderName(vars))
 		if headerSnapshot != nil {
-			logCtx.ensureRequestMap()["headers"] = headerSnapshot
+			logCtx.ensureRequestMap()["headers"] = logCtx.redactHeaders(headerSnapshot)
 		}
 	}
 
-	fmt.Printf("%s⇒ %s%s %s %s%s\n",
+	fmt.Fprintf(r.output(), "%s⇒ %s%s %s %s%s\n",
 		colorBlue,
 		step.Name,
 		colorReset,
@@ -867,13 +1340,39 @@ func (r *FlowRunner) executeStep(ctx context.Context, step Step, vars map[string
 		colorReset,
 	)
 
-	resp, err := r.client.Do(req)
+	resp, err := client.Do(req)
+	if err == nil && authInjected && resp.StatusCode == http.StatusUnauthorized && r.refreshableAuth() {
+		resp, err = r.retryUnauthorized(stepCtx, client, req, step, vars, resp)
+	}
 	if err != nil {
 		return fmt.Errorf("send request for step %q: %w", step.Name, err)
 	}
 	defer resp.Body.Close()
 
-	respBytes, err := io.ReadAll(resp.Body)
+	var (
+		respBytes []byte
+		stream    *streamResult
+		file      *savedFile
+	)
+	statusOK := step.ExpectStatus == 0 || resp.StatusCode == step.ExpectStatus
+	switch {
+	case step.SaveBodyTo != "" && statusOK:
+		path := resolveRelativePath(step.dir, render(step.SaveBodyTo, vars))
+		saved, fileErr := writeResponseFile(resp.Body, path)
+		if fileErr != nil {
+			return fmt.Errorf("save response body for step %q to %s: %w", step.Name, path, fileErr)
+		}
+		file = &saved
+	case step.Stream != nil && statusOK:
+		result, streamErr := readStream(resp.Body, step.Stream, vars, streamDuration)
+		if streamErr == nil {
+			stream = &result
+			respBytes, streamErr = json.Marshal(result.events)
+		}
+		err = streamErr
+	default:
+		respBytes, err = io.ReadAll(resp.Body)
+	}
 	if err != nil {
 		return fmt.Errorf("read response body for step %q: %w", step.Name, err)
 	}
@@ -881,12 +1380,17 @@ func (r *FlowRunner) executeStep(ctx context.Context, step Step, vars map[string
 	if logCtx != nil {
 		respMap := logCtx.ensureResponseMap()
 		respMap["body"] = normalizeJSONBytes(respBytes)
+		if file != nil {
+			respMap["body"] = file
+		}
 		respMap["status"] = resp.StatusCode
-		respMap["headers"] = flattenHTTPHeader(resp.Header)
+		respMap["headers"] = logCtx.redactHeaders(flattenHTTPHeader(resp.Header))
 	}
 
+	outcome.record(resp.StatusCode, respBytes, 0)
+
 	if step.ExpectStatus != 0 && resp.StatusCode != step.ExpectStatus {
-		fmt.Printf("%s✖ %s: expected %d, got %d%s\n",
+		fmt.Fprintf(r.output(), "%s✖ %s: expected %d, got %d%s\n",
 			colorRed,
 			step.Name,
 			step.ExpectStatus,
@@ -894,23 +1398,77 @@ func (r *FlowRunner) executeStep(ctx context.Context, step Step, vars map[string
 			colorReset,
 		)
 
-		fmt.Println(string(respBytes))
+		fmt.Fprintln(r.output(), string(respBytes))
 
 		return fmt.Errorf("step %q failed: unexpected status %d", step.Name, resp.StatusCode)
 	}
 
-	if err := validateAndSaveJSON(step, respBytes, vars, "response"); err != nil {
+	if stream != nil {
+		fmt.Fprintf(r.output(), "%s↳ %s: %d events (%s)%s\n", colorGray, step.Name, len(stream.events), stream.reason, colorReset)
+		if len(step.Stream.Until) > 0 && !stream.matched {
+			fmt.Fprintf(r.output(), "%s✖ %s: no event matched until (%s)%s\n", colorRed, step.Name, stream.reason, colorReset)
+			return fmt.Errorf("step %q failed: no stream event matched until (%s)", step.Name, stream.reason)
+		}
+	}
+
+	if file != nil {
+		vars[lastFileVar] = file.Path
+		fmt.Fprintf(r.output(), "%s↳ %s: saved %d bytes to %s%s\n", colorGray, step.Name, file.Size, file.Path, colorReset)
+
+		if err := r.validateFileExpectations(step, *file, vars); err != nil {
+			return err
+		}
+
+		if golden := strings.TrimSpace(render(step.Golden, vars)); golden != "" {
+			if err := compareGolden(file.Path, resolveRelativePath(step.dir, golden)); err != nil {
+				fmt.Fprintf(r.output(), "%s✖ %s: %v%s\n", colorRed, step.Name, err, colorReset)
+				return fmt.Errorf("step %q failed: %s %w", step.Name, file.Path, err)
+			}
+		}
+	}
+
+	payload := respBytes
+	if step.GraphQL != nil {
+		if payload, err = graphQLData(step, respBytes); err != nil {
+			fmt.Fprintf(r.output(), "%s✖ %v%s\n", colorRed, err, colorReset)
+			return err
+		}
+	}
+
+	bodySaves, metaSaves := splitHTTPSaves(step.Save)
+	bodyStep := step
+	bodyStep.Save = bodySaves
+	if err := r.validateAndSaveJSON(bodyStep, payload, vars, "response"); err != nil {
+		return err
+	}
+
+	meta := httpResponseMeta{
+		status:  resp.StatusCode,
+		header:  resp.Header,
+		cookies: responseCookies(client.Jar, resp),
+	}
+	r.saveResponseMeta(metaSaves, meta, vars)
+
+	if err := r.validateExpectations(step, payload, vars, "response"); err != nil {
+		return err
+	}
+
+	if err := r.validateHeaderExpectations(step, resp.Header, vars); err != nil {
+		return err
+	}
+
+	if err := r.validateNamedExpectations(step, step.ExpectCookies, meta.cookies, vars, "cookies"); err != nil {
 		return err
 	}
 
 	r.recordExport(step, vars)
 
-	fmt.Printf("%s✓ %s%s\n", colorGreen, step.Name, colorReset)
+	fmt.Fprintf(r.output(), "%s✓ %s%s\n", colorGreen, step.Name, colorReset)
 
 	return nil
 }
 
-func (r *FlowRunner) executeMongoStep(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext) error {
+func (r *FlowRunner) executeMongoStep(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext, outcome *stepOutcome) error {
 	cfg := step.Mongo
 	if cfg == nil {
 		return fmt.Errorf("step %q missing mongo configuration", step.Name)
@@ -952,19 +1510,11 @@ func (r *FlowRunner) executeMongoStep(ctx context.Context, step Step, vars map[s
 	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(step.TimeoutSeconds)*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(stepCtx, options.Client().ApplyURI(uri))
+	client, release, err := r.mongoClient(stepCtx, uri)
 	if err != nil {
-		return fmt.Errorf("connect mongo for step %q: %w", step.Name, err)
-	}
-	defer func() {
-		disconnectCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-		_ = client.Disconnect(disconnectCtx)
-	}()
-
-	if err := client.Ping(stepCtx, nil); err != nil {
-		return fmt.Errorf("ping mongo for step %q: %w", step.Name, err)
+		return fmt.Errorf("step %q: %w", step.Name, err)
 	}
+	defer release()
 
 	db := client.Database(dbName)
 
@@ -986,7 +1536,7 @@ func (r *FlowRunner) executeMongoStep(ctx context.Context, step Step, vars map[s
 		targetLabel = fmt.Sprintf("%s.%s", dbName, collName)
 	}
 
-	fmt.Printf("%s⇒ %s%s Mongo %s %s%s\n",
+	fmt.Fprintf(r.output(), "%s⇒ %s%s Mongo %s %s%s\n",
 		colorBlue,
 		step.Name,
 		colorReset,
@@ -1204,12 +1754,14 @@ func (r *FlowRunner) executeMongoStep(ctx context.Context, step Step, vars map[s
 		return fmt.Errorf("step %q: unsupported mongo operation %q", step.Name, cfg.Operation)
 	}
 
-	if err := ensureExpectedAffectedRows(step, affected); err != nil {
+	outcome.record(0, resultPayload, affected)
+
+	if err := r.ensureExpectedAffectedRows(step, affected); err != nil {
 		return err
 	}
 
 	if len(step.Save) > 0 && len(resultPayload) > 0 && json.Valid(resultPayload) {
-		saveValues(resultPayload, step.Save, vars)
+		r.saveValues(resultPayload, step.Save, vars)
 	}
 
 	if logCtx != nil {
@@ -1218,9 +1770,13 @@ func (r *FlowRunner) executeMongoStep(ctx context.Context, step Step, vars map[s
 		respMap["body"] = normalizeJSONBytes(resultPayload)
 	}
 
+	if err := r.validateExpectations(step, resultPayload, vars, "result"); err != nil {
+		return err
+	}
+
 	r.recordExport(step, vars)
 
-	fmt.Printf("%s✓ %s%s\n", colorGreen, step.Name, colorReset)
+	fmt.Fprintf(r.output(), "%s✓ %s%s\n", colorGreen, step.Name, colorReset)
 
 	return nil
 }
@@ -1235,6 +1791,14 @@ const (
 	mongoOpCommand   = "command"
 )
 
+// Save sources that read HTTP response metadata instead of the JSON body.
+// Each has a prefix no gjson body path uses, so `status` stays a body field.
+const (
+	statusSaveSource = "response:status"
+	headerSavePrefix = "header:"
+	cookieSavePrefix = "cookie:"
+)
+
 func normalizeMongoOperation(op string) string {
 	switch strings.ToLower(strings.TrimSpace(op)) {
 	case "", "findone", "find_one":
@@ -1288,7 +1852,7 @@ func bsonToJSON(value any) ([]byte, error) {
 	return bson.MarshalExtJSON(value, true, true)
 }
 
-func (r *FlowRunner) executeGRPCStep(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext) error {
+func (r *FlowRunner) executeGRPCStep(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext, outcome *stepOutcome) error {
 	cfg := step.GRPC
 	if cfg == nil {
 		return fmt.Errorf("step %q missing grpc configuration", step.Name)
@@ -1314,17 +1878,26 @@ func (r *FlowRunner) executeGRPCStep(ctx context.Context, step Step, vars map[st
 	reflectionHeaders := buildGRPCHeaders(cfg.ReflectionMetadata, vars)
 
 	if logCtx != nil {
+		logCtx.addSensitiveHeaders(r.authHeaderName(vars))
 		reqMap := logCtx.ensureRequestMap()
 		reqMap["target"] = target
 		reqMap["method"] = method
 		reqMap["payload"] = normalizeJSONValue(payload)
-		reqMap["metadata"] = headers
+		reqMap["metadata"] = logCtx.redactMetadata(headers)
 		if len(reflectionHeaders) > 0 {
-			reqMap["reflection_metadata"] = reflectionHeaders
+			reqMap["reflection_metadata"] = logCtx.redactMetadata(reflectionHeaders)
 		}
 	}
 
-	fmt.Printf("%s⇒ %s%s gRPC %s %s%s\n",
+	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(step.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	authMetadata, err := r.grpcAuthMetadata(stepCtx, cfg, vars)
+	if err != nil {
+		return fmt.Errorf("authenticate step %q: %w", step.Name, err)
+	}
+
+	fmt.Fprintf(r.output(), "%s⇒ %s%s gRPC %s %s%s\n",
 		colorBlue,
 		step.Name,
 		colorReset,
@@ -1333,16 +1906,13 @@ func (r *FlowRunner) executeGRPCStep(ctx context.Context, step Step, vars map[st
 		colorReset,
 	)
 
-	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(step.TimeoutSeconds)*time.Second)
-	defer cancel()
-
-	conn, err := dialGRPC(stepCtx, target, cfg, vars)
+	conn, connKey, release, err := r.grpcConn(stepCtx, target, cfg, vars)
 	if err != nil {
 		return fmt.Errorf("dial grpc for step %q: %w", step.Name, err)
 	}
-	defer conn.Close()
+	defer release()
 
-	descSource, cleanup, err := buildDescriptorSource(stepCtx, conn, cfg, vars, reflectionHeaders)
+	descSource, cleanup, err := r.grpcDescriptorSource(stepCtx, conn, connKey, cfg, vars, reflectionHeaders)
 	if err != nil {
 		return fmt.Errorf("prepare descriptor source for step %q: %w", step.Name, err)
 	}
@@ -1350,26 +1920,51 @@ func (r *FlowRunner) executeGRPCStep(ctx context.Context, step Step, vars map[st
 		defer cleanup()
 	}
 
-	parserInput := strings.NewReader(payload)
-	parser, formatter, err := grpcurl.RequestParserAndFormatter(format, descSource, parserInput, grpcurl.FormatOptions{
-		EmitJSONDefaultFields: true,
-	})
-	if err != nil {
-		return fmt.Errorf("build grpc request parser for step %q: %w", step.Name, err)
-	}
+	invoke := func(headers []string) (*grpcCaptureEventHandler, *status.Status, error) {
+		parserInput := strings.NewReader(payload)
+		parser, formatter, err := grpcurl.RequestParserAndFormatter(format, descSource, parserInput, grpcurl.FormatOptions{
+			EmitJSONDefaultFields: true,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("build grpc request parser for step %q: %w", step.Name, err)
+		}
 
-	handler := &grpcCaptureEventHandler{formatter: formatter}
-	if err := grpcurl.InvokeRPC(stepCtx, descSource, conn, method, headers, handler, parser.Next); err != nil {
-		return fmt.Errorf("grpc call for step %q: %w", step.Name, err)
+		handler := &grpcCaptureEventHandler{formatter: formatter}
+		if err := grpcurl.InvokeRPC(stepCtx, descSource, conn, method, headers, handler, parser.Next); err != nil {
+			return nil, nil, fmt.Errorf("grpc call for step %q: %w", step.Name, err)
+		}
+
+		if err := handler.Error(); err != nil {
+			return nil, nil, fmt.Errorf("process grpc response for step %q: %w", step.Name, err)
+		}
+
+		respStatus := handler.Status()
+		if respStatus == nil {
+			respStatus = status.New(codes.OK, "")
+		}
+
+		return handler, respStatus, nil
 	}
 
-	if err := handler.Error(); err != nil {
-		return fmt.Errorf("process grpc response for step %q: %w", step.Name, err)
+	handler, respStatus, err := invoke(append(headers, authMetadata...))
+	if err != nil {
+		return err
 	}
 
-	respStatus := handler.Status()
-	if respStatus == nil {
-		respStatus = status.New(codes.OK, "")
+	// A rejected token is refreshed once, like a 401 on HTTP steps.
+	if len(authMetadata) > 0 && respStatus.Code() == codes.Unauthenticated && r.refreshableAuth() {
+		fmt.Fprintf(r.output(), "%s↻ %s: unauthenticated, refreshing token and retrying%s\n", colorGray, step.Name, colorReset)
+		r.invalidateAuth(vars)
+
+		authMetadata, err = r.grpcAuthMetadata(stepCtx, cfg, vars)
+		if err != nil {
+			return fmt.Errorf("authenticate step %q: %w", step.Name, err)
+		}
+
+		handler, respStatus, err = invoke(append(headers, authMetadata...))
+		if err != nil {
+			return err
+		}
 	}
 
 	expectedCode := strings.TrimSpace(cfg.ExpectCode)
@@ -1391,6 +1986,8 @@ func (r *FlowRunner) executeGRPCStep(ctx context.Context, step Step, vars map[st
 	}
 
 	respBytes := handler.ResponsePayload()
+	outcome.record(0, respBytes, 0)
+
 	if logCtx != nil {
 		respMap := logCtx.ensureResponseMap()
 		respMap["body"] = normalizeJSONBytes(respBytes)
@@ -1398,13 +1995,17 @@ func (r *FlowRunner) executeGRPCStep(ctx context.Context, step Step, vars map[st
 		respMap["status_msg"] = respStatus.Message()
 	}
 
-	if err := validateAndSaveJSON(step, respBytes, vars, "response"); err != nil {
+	if err := r.validateAndSaveJSON(step, respBytes, vars, "response"); err != nil {
+		return err
+	}
+
+	if err := r.validateExpectations(step, respBytes, vars, "response"); err != nil {
 		return err
 	}
 
 	r.recordExport(step, vars)
 
-	fmt.Printf("%s✓ %s%s\n", colorGreen, step.Name, colorReset)
+	fmt.Fprintf(r.output(), "%s✓ %s%s\n", colorGreen, step.Name, colorReset)
 
 	return nil
 }
@@ -1741,16 +2342,29 @@ func (cs compositeDescriptorSource) AllExtensionsForType(typeName string) ([]*de
 	return exts, nil
 }
 
-func executeSQLAndMaybeSave(ctx context.Context, db *sql.DB, step Step, sqlStmt string, vars map[string]string) (int, error) {
-	if len(step.Save) == 0 {
-		return runSQLWithoutSave(ctx, db, step, sqlStmt)
+func (r *FlowRunner) executeSQLAndMaybeSave(ctx context.Context, db *sql.DB, dialect sqlDialect, step Step, sqlStmt string, args []any, vars map[string]string) (int, *sqlResult, error) {
+	if len(step.Save) == 0 && !step.needsSQLRows() {
+		affectedRows, err := r.runSQLWithoutSave(ctx, db, dialect, step, sqlStmt, args)
+		return affectedRows, nil, err
 	}
 
-	return runSQLAndSave(ctx, db, step, sqlStmt, vars)
+	// A write without RETURNING yields no rows, so reading it through Query
+	// would lose the changed-row count. Run it through Exec and hand the row
+	// assertions an empty result instead.
+	if len(step.Save) == 0 && !returnsRows(sqlStmt) {
+		affectedRows, err := r.runSQLWithoutSave(ctx, db, dialect, step, sqlStmt, args)
+		return affectedRows, &sqlResult{}, err
+	}
+
+	return r.runSQLAndSave(ctx, db, step, sqlStmt, args, vars)
 }
 
-func runSQLWithoutSave(ctx context.Context, db *sql.DB, step Step, sqlStmt string) (int, error) {
-	results, err := db.ExecContext(ctx, sqlStmt)
+func (r *FlowRunner) runSQLWithoutSave(ctx context.Context, db *sql.DB, dialect sqlDialect, step Step, sqlStmt string, args []any) (int, error) {
+	if !dialect.countsQueryRows && isQueryStatement(sqlStmt) {
+		return countQueryRows(ctx, db, step, sqlStmt, args)
+	}
+
+	results, err := db.ExecContext(ctx, sqlStmt, args...)
 	if err != nil {
 		return 0, fmt.Errorf("execute sql for step %q: %w", step.Name, err)
 	}
@@ -1763,16 +2377,40 @@ func runSQLWithoutSave(ctx context.Context, db *sql.DB, step Step, sqlStmt strin
 	return int(rowsAffected), nil
 }
 
-func runSQLAndSave(ctx context.Context, db *sql.DB, step Step, sqlStmt string, vars map[string]string) (int, error) {
-	rows, err := db.QueryContext(ctx, sqlStmt)
+func countQueryRows(ctx context.Context, db *sql.DB, step Step, sqlStmt string, args []any) (int, error) {
+	rows, err := db.QueryContext(ctx, sqlStmt, args...)
 	if err != nil {
 		return 0, fmt.Errorf("query sql for step %q: %w", step.Name, err)
 	}
 	defer rows.Close()
 
+	count := 0
+	for rows.Next() {
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate rows for step %q: %w", step.Name, err)
+	}
+
+	return count, nil
+}
+
+func (r *FlowRunner) runSQLAndSave(ctx context.Context, db *sql.DB, step Step, sqlStmt string, args []any, vars map[string]string) (int, *sqlResult, error) {
+	rows, err := db.QueryContext(ctx, sqlStmt, args...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("query sql for step %q: %w", step.Name, err)
+	}
+	defer rows.Close()
+
 	columns, err := rows.Columns()
 	if err != nil {
-		return 0, fmt.Errorf("fetch columns for step %q: %w", step.Name, err)
+		return 0, nil, fmt.Errorf("fetch columns for step %q: %w", step.Name, err)
+	}
+
+	var result *sqlResult
+	if step.needsSQLRows() {
+		result = &sqlResult{columns: columns}
 	}
 
 	columnIndex := make(map[string]int, len(columns))
@@ -1792,32 +2430,36 @@ func runSQLAndSave(ctx context.Context, db *sql.DB, step Step, sqlStmt string, v
 
 	for rows.Next() {
 		if err := rows.Scan(scanTargets...); err != nil {
-			return 0, fmt.Errorf("scan row for step %q: %w", step.Name, err)
+			return 0, nil, fmt.Errorf("scan row for step %q: %w", step.Name, err)
 		}
 
 		if !savedFirstRow {
-			if err := saveRowValues(step, vars, values, columnIndex); err != nil {
-				return 0, err
+			if err := r.saveRowValues(step, vars, values, columnIndex); err != nil {
+				return 0, nil, err
 			}
 
 			savedFirstRow = true
 		}
 
+		if result != nil {
+			result.rows = append(result.rows, slices.Clone(values))
+		}
+
 		affectedRows++
 	}
 
 	if err := rows.Err(); err != nil {
-		return 0, fmt.Errorf("iterate rows for step %q: %w", step.Name, err)
+		return 0, nil, fmt.Errorf("iterate rows for step %q: %w", step.Name, err)
 	}
 
-	if affectedRows == 0 {
-		return 0, fmt.Errorf("execute sql for step %q: no rows returned to save", step.Name)
+	if affectedRows == 0 && len(step.Save) > 0 {
+		return 0, nil, fmt.Errorf("execute sql for step %q: no rows returned to save", step.Name)
 	}
 
-	return affectedRows, nil
+	return affectedRows, result, nil
 }
 
-func saveRowValues(step Step, vars map[string]string, rowValues []any, columnIndex map[string]int) error {
+func (r *FlowRunner) saveRowValues(step Step, vars map[string]string, rowValues []any, columnIndex map[string]int) error {
 	for varName, column := range step.Save {
 		target := strings.TrimSpace(column)
 		if target == "" {
@@ -1836,7 +2478,7 @@ func saveRowValues(step Step, vars map[string]string, rowValues []any, columnInd
 
 		text := anyToString(val)
 		vars[varName] = text
-		fmt.Printf("   %ssaved%s %s = %s\n",
+		fmt.Fprintf(r.output(), "   %ssaved%s %s = %s\n",
 			colorGray,
 			colorReset,
 			varName,
@@ -1856,12 +2498,12 @@ func anyToString(val any) string {
 	}
 }
 
-func ensureExpectedAffectedRows(step Step, affectedRows int) error {
+func (r *FlowRunner) ensureExpectedAffectedRows(step Step, affectedRows int) error {
 	if step.ExpectAffectedRows == 0 || affectedRows == step.ExpectAffectedRows {
 		return nil
 	}
 
-	fmt.Printf("%s✖ %s: expected %d affected rows, got %d%s\n",
+	fmt.Fprintf(r.output(), "%s✖ %s: expected %d affected rows, got %d%s\n",
 		colorRed,
 		step.Name,
 		step.ExpectAffectedRows,
@@ -1872,7 +2514,23 @@ func ensureExpectedAffectedRows(step Step, affectedRows int) error {
 	return fmt.Errorf("step %q failed: unexpected affected rows %d", step.Name, affectedRows)
 }
 
-func executeSQLStep(ctx context.Context, step Step, sqlStmt string, vars map[string]string, logCtx *stepLogContext) error {
+func (r *FlowRunner) ensureExpectedRows(step Step, rowCount int) error {
+	if step.ExpectRows == nil || rowCount == *step.ExpectRows {
+		return nil
+	}
+
+	fmt.Fprintf(r.output(), "%s✖ %s: expected %d rows, got %d%s\n",
+		colorRed,
+		step.Name,
+		*step.ExpectRows,
+		rowCount,
+		colorReset,
+	)
+
+	return fmt.Errorf("step %q failed: expected %d rows, got %d", step.Name, *step.ExpectRows, rowCount)
+}
+
+func (r *FlowRunner) executeSQLStep(ctx context.Context, step Step, sqlStmt string, vars map[string]string, logCtx *stepLogContext, outcome *stepOutcome) error {
 	dbURL := strings.TrimSpace(render(step.DatabaseURL, vars))
 	if dbURL == "" {
 		dbURL = strings.TrimSpace(vars["database_url"])
@@ -1886,24 +2544,40 @@ func executeSQLStep(ctx context.Context, step Step, sqlStmt string, vars map[str
 		return fmt.Errorf("step %q requires database_url (var, step override, or DATABASE_URL env)", step.Name)
 	}
 
+	dialect, dsn, err := resolveSQLDriver(render(step.Driver, vars), dbURL)
+	if err != nil {
+		return fmt.Errorf("step %q: %w", step.Name, err)
+	}
+
+	args, err := renderSQLParams(step.Params, vars)
+	if err != nil {
+		return fmt.Errorf("step %q: %w", step.Name, err)
+	}
+
+	sqlStmt, args, err = dialect.bindSQL(sqlStmt, args)
+	if err != nil {
+		return fmt.Errorf("step %q: %w", step.Name, err)
+	}
+
 	if logCtx != nil {
-		logCtx.ensureRequestMap()["sql"] = sqlStmt
+		reqMap := logCtx.ensureRequestMap()
+		reqMap["sql"] = sqlStmt
+		reqMap["driver"] = dialect.name
+		if len(args) > 0 {
+			reqMap["params"] = args
+		}
 	}
 
 	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(step.TimeoutSeconds)*time.Second)
 	defer cancel()
 
-	db, err := sql.Open("postgres", dbURL)
+	db, release, err := r.sqlDB(stepCtx, dialect, dsn)
 	if err != nil {
-		return fmt.Errorf("open database for step %q: %w", step.Name, err)
-	}
-	defer db.Close()
-
-	if err := db.PingContext(stepCtx); err != nil {
-		return fmt.Errorf("ping database for step %q: %w", step.Name, err)
+		return fmt.Errorf("step %q: %w", step.Name, err)
 	}
+	defer release()
 
-	fmt.Printf("%s⇒ %s%s SQL %s%s\n",
+	fmt.Fprintf(r.output(), "%s⇒ %s%s SQL %s%s\n",
 		colorBlue,
 		step.Name,
 		colorReset,
@@ -1911,25 +2585,65 @@ func executeSQLStep(ctx context.Context, step Step, sqlStmt string, vars map[str
 		colorReset,
 	)
 
-	affectedRows, err := executeSQLAndMaybeSave(stepCtx, db, step, sqlStmt, vars)
+	affectedRows, result, err := r.executeSQLAndMaybeSave(stepCtx, db, dialect, step, sqlStmt, args, vars)
 	if err != nil {
 		return err
 	}
 
+	var rowsJSON []byte
+	if result != nil {
+		if rowsJSON, err = result.json(); err != nil {
+			return fmt.Errorf("encode rows for step %q: %w", step.Name, err)
+		}
+	}
+
 	if logCtx != nil {
-		logCtx.ensureResponseMap()["affected_rows"] = affectedRows
+		respMap := logCtx.ensureResponseMap()
+		respMap["affected_rows"] = affectedRows
+		if rowsJSON != nil {
+			respMap["rows"] = normalizeJSONBytes(rowsJSON)
+		}
 	}
 
-	if err := ensureExpectedAffectedRows(step, affectedRows); err != nil {
+	outcome.record(0, rowsJSON, affectedRows)
+
+	if name := strings.TrimSpace(step.SaveRows); name != "" {
+		vars[name] = string(rowsJSON)
+		fmt.Fprintf(r.output(), "   %ssaved%s %s = %s\n",
+			colorGray,
+			colorReset,
+			name,
+			trimLongString(string(rowsJSON)),
+		)
+	}
+
+	if err := r.ensureExpectedAffectedRows(step, affectedRows); err != nil {
+		return err
+	}
+
+	rowCount := affectedRows
+	if result != nil {
+		rowCount = len(result.rows)
+	}
+
+	if err := r.ensureExpectedRows(step, rowCount); err != nil {
 		return err
 	}
 
-	fmt.Printf("%s✓ %s%s\n", colorGreen, step.Name, colorReset)
+	if err := r.validateExpectations(step, rowsJSON, vars, "rows"); err != nil {
+		return err
+	}
+
+	if err := r.ensureExpectedResult(step, result, rowsJSON, vars, logCtx); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(r.output(), "%s✓ %s%s\n", colorGreen, step.Name, colorReset)
 
 	return nil
 }
 
-func saveValues(respBytes []byte, save map[string]string, vars map[string]string) {
+func (r *FlowRunner) saveValues(respBytes []byte, save map[string]string, vars map[string]string) {
 	saveCount := 0
 	for varName, jsonPath := range save {
 		val := gjson.GetBytes(respBytes, jsonPath).String()
@@ -1940,7 +2654,7 @@ func saveValues(respBytes []byte, save map[string]string, vars map[string]string
 
 		vars[varName] = val
 		saveCount++
-		fmt.Printf("   %ssaved%s %s = %s\n",
+		fmt.Fprintf(r.output(), "   %ssaved%s %s = %s\n",
 			colorGray,
 			colorReset,
 			varName,
@@ -1949,19 +2663,107 @@ func saveValues(respBytes []byte, save map[string]string, vars map[string]string
 	}
 
 	if saveCount == 0 && len(save) > 0 {
-		fmt.Printf("   %sno values saved from response%s\n", colorGray, colorReset)
+		fmt.Fprintf(r.output(), "   %sno values saved from response%s\n", colorGray, colorReset)
 
 		// actual response for debugging
-		fmt.Printf("   %sresponse: %s%s\n", colorGray, string(respBytes), colorReset)
+		fmt.Fprintf(r.output(), "   %sresponse: %s%s\n", colorGray, string(respBytes), colorReset)
 	} else if saveCount < len(save) {
-		fmt.Printf("   %ssome values not found to save from response%s\n", colorGray, colorReset)
+		fmt.Fprintf(r.output(), "   %ssome values not found to save from response%s\n", colorGray, colorReset)
 
 		// actual response for debugging
-		fmt.Printf("   %sresponse: %s%s\n", colorGray, string(respBytes), colorReset)
+		fmt.Fprintf(r.output(), "   %sresponse: %s%s\n", colorGray, string(respBytes), colorReset)
 	}
 }
 
-func validateAndSaveJSON(step Step, payload []byte, vars map[string]string, contextLabel string) error {
+// httpResponseMeta holds the parts of an HTTP response, besides the JSON
+// body, that save sources and expectations can read.
+type httpResponseMeta struct {
+	status  int
+	header  http.Header
+	cookies map[string]string
+}
+
+// lookup resolves a save source such as `status`, `header:Location`, or
+// `cookie:session`.
+func (m httpResponseMeta) lookup(source string) (string, bool) {
+	switch {
+	case source == statusSaveSource:
+		return strconv.Itoa(m.status), true
+	case strings.HasPrefix(source, headerSavePrefix):
+		values := m.header.Values(strings.TrimSpace(strings.TrimPrefix(source, headerSavePrefix)))
+		if len(values) == 0 {
+			return "", false
+		}
+		return strings.Join(values, ", "), true
+	case strings.HasPrefix(source, cookieSavePrefix):
+		value, ok := m.cookies[strings.TrimSpace(strings.TrimPrefix(source, cookieSavePrefix))]
+		return value, ok
+	}
+
+	return "", false
+}
+
+func isResponseMetaSource(source string) bool {
+	return source == statusSaveSource ||
+		strings.HasPrefix(source, headerSavePrefix) ||
+		strings.HasPrefix(source, cookieSavePrefix)
+}
+
+// splitHTTPSaves separates save entries that read response metadata (status,
+// headers, cookies) from gjson paths into the response body.
+func splitHTTPSaves(save map[string]string) (body, meta map[string]string) {
+	for varName, source := range save {
+		if !isResponseMetaSource(strings.TrimSpace(source)) {
+			if body == nil {
+				body = make(map[string]string)
+			}
+			body[varName] = source
+			continue
+		}
+
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[varName] = strings.TrimSpace(source)
+	}
+
+	return body, meta
+}
+
+func (r *FlowRunner) saveResponseMeta(save map[string]string, meta httpResponseMeta, vars map[string]string) {
+	for varName, source := range save {
+		value, ok := meta.lookup(source)
+		if !ok {
+			fmt.Fprintf(r.output(), "   %sno %s in response to save%s\n", colorGray, source, colorReset)
+			continue
+		}
+
+		vars[varName] = value
+		fmt.Fprintf(r.output(), "   %ssaved%s %s = %s\n",
+			colorGray,
+			colorReset,
+			varName,
+			trimLongString(value),
+		)
+	}
+}
+
+// validateHeaderExpectations runs expect_headers matchers; header names are
+// matched case-insensitively and repeated headers are joined with ", ".
+func (r *FlowRunner) validateHeaderExpectations(step Step, header http.Header, vars map[string]string) error {
+	if len(step.ExpectHeaders) == 0 {
+		return nil
+	}
+
+	expect := make(map[string]Matcher, len(step.ExpectHeaders))
+	for name, matcher := range step.ExpectHeaders {
+		expect[http.CanonicalHeaderKey(strings.TrimSpace(name))] = matcher
+	}
+
+	return r.validateNamedExpectations(step, expect, flattenHTTPHeader(header), vars, "headers")
+}
+
+func (r *FlowRunner) validateAndSaveJSON(step Step, payload []byte, vars map[string]string, contextLabel string) error {
 	if len(step.Save) == 0 || len(payload) == 0 {
 		return nil
 	}
@@ -1973,13 +2775,13 @@ func validateAndSaveJSON(step Step, payload []byte, vars map[string]string, cont
 	cleanPayload := bytes.TrimPrefix(payload, utf8BOM)
 
 	if !json.Valid(cleanPayload) {
-		fmt.Printf("%s→ Invalid JSON %s for step %q%s\n",
+		fmt.Fprintf(r.output(), "%s→ Invalid JSON %s for step %q%s\n",
 			colorGray,
 			contextLabel,
 			step.Name,
 			colorReset,
 		)
-		fmt.Printf("   %s%s: %s%s\n",
+		fmt.Fprintf(r.output(), "   %s%s: %s%s\n",
 			colorGray,
 			contextLabel,
 			string(payload),
@@ -1988,7 +2790,7 @@ func validateAndSaveJSON(step Step, payload []byte, vars map[string]string, cont
 		return fmt.Errorf("step %q failed: invalid JSON %s", step.Name, contextLabel)
 	}
 
-	saveValues(cleanPayload, step.Save, vars)
+	r.saveValues(cleanPayload, step.Save, vars)
 	return nil
 }
 
@@ -2010,6 +2812,22 @@ func render(tmpl string, vars map[string]string) string {
 	return strings.TrimSpace(buf.String())
 }
 
+// renderExact executes a template like render but keeps surrounding
+// whitespace and reports template errors instead of returning the input.
+func renderExact(tmpl string, vars map[string]string) (string, error) {
+	t, err := template.New("flow").Funcs(templateFuncs).Option("missingkey=zero").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parse: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("evaluate: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
 func trimLongString(s string) string {
 	if len(s) <= maxDisplayedStringLen {
 		return s
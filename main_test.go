@@ -1,19 +1,43 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/fullstorydev/grpcurl"
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
 	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
 )
 
 type roundTripFunc func(*http.Request) (*http.Response, error)
@@ -329,17 +353,18 @@ func TestTrimLongString(t *testing.T) {
 }
 
 func TestEnsureExpectedAffectedRows(t *testing.T) {
+	runner := &FlowRunner{}
 	step := Step{Name: "users", ExpectAffectedRows: 2}
-	if err := ensureExpectedAffectedRows(step, 2); err != nil {
+	if err := runner.ensureExpectedAffectedRows(step, 2); err != nil {
 		t.Fatalf("expected success, got %v", err)
 	}
 
-	if err := ensureExpectedAffectedRows(step, 1); err == nil {
+	if err := runner.ensureExpectedAffectedRows(step, 1); err == nil {
 		t.Fatalf("expected mismatch error")
 	}
 
 	step.ExpectAffectedRows = 0
-	if err := ensureExpectedAffectedRows(step, 5); err != nil {
+	if err := runner.ensureExpectedAffectedRows(step, 5); err != nil {
 		t.Fatalf("expect zero should ignore, got %v", err)
 	}
 }
@@ -354,6 +379,7 @@ func TestRender(t *testing.T) {
 }
 
 func TestSaveValues(t *testing.T) {
+	runner := &FlowRunner{}
 	resp := []byte(`{"user":{"id":"123","email":"codex@example.com"}}`)
 	saveMap := map[string]string{
 		"user_id":    "user.id",
@@ -362,7 +388,7 @@ func TestSaveValues(t *testing.T) {
 	}
 	vars := map[string]string{}
 
-	saveValues(resp, saveMap, vars)
+	runner.saveValues(resp, saveMap, vars)
 
 	if vars["user_id"] != "123" {
 		t.Fatalf("expected user_id=123, got %q", vars["user_id"])
@@ -437,6 +463,7 @@ func TestVarExporterWritesRecordsOnClose(t *testing.T) {
 }
 
 func TestValidateAndSaveJSONHandlesBOM(t *testing.T) {
+	runner := &FlowRunner{}
 	step := Step{
 		Name: "bom-step",
 		Save: map[string]string{
@@ -446,7 +473,7 @@ func TestValidateAndSaveJSONHandlesBOM(t *testing.T) {
 	payload := append([]byte{0xEF, 0xBB, 0xBF}, []byte(`{"value":"123"}`)...)
 	vars := map[string]string{}
 
-	if err := validateAndSaveJSON(step, payload, vars, "response"); err != nil {
+	if err := runner.validateAndSaveJSON(step, payload, vars, "response"); err != nil {
 		t.Fatalf("validateAndSaveJSON: %v", err)
 	}
 
@@ -456,6 +483,7 @@ func TestValidateAndSaveJSONHandlesBOM(t *testing.T) {
 }
 
 func TestValidateAndSaveJSONInvalidPayload(t *testing.T) {
+	runner := &FlowRunner{}
 	step := Step{
 		Name: "bad-json",
 		Save: map[string]string{
@@ -464,7 +492,7 @@ func TestValidateAndSaveJSONInvalidPayload(t *testing.T) {
 	}
 	payload := []byte{0xEF, 0xBB, 0xBF, 'n', 'o', 'p'}
 
-	if err := validateAndSaveJSON(step, payload, map[string]string{}, "response"); err == nil {
+	if err := runner.validateAndSaveJSON(step, payload, map[string]string{}, "response"); err == nil {
 		t.Fatalf("expected error for invalid JSON payload")
 	}
 }
@@ -547,3 +575,2975 @@ func TestRunLoggerWritesArtifacts(t *testing.T) {
 		t.Fatalf("html log missing marker")
 	}
 }
+
+func TestEvaluateExpectations(t *testing.T) {
+	payload := []byte(`{"data":{"id":"42","email":"codex@example.com","total":12.5,"items":[1,2,3],"active":true}}`)
+	vars := map[string]string{"user_id": "42"}
+	yes, no := true, false
+	equals := "{{.user_id}}"
+	gt, lt, length, wrongLength := 10.0, 20.0, 3, 2
+
+	passing := map[string]Matcher{
+		"data.id":      {Equals: &equals, Regex: `^\d+$`},
+		"data.email":   {Contains: "@example.com", Type: "string"},
+		"data.total":   {GT: &gt, LT: &lt},
+		"data.items":   {Length: &length, Contains: "2", Type: "array"},
+		"data.active":  {Exists: &yes, Type: "boolean"},
+		"data.deleted": {Absent: &yes},
+	}
+	if failures := evaluateExpectations(payload, passing, vars); len(failures) != 0 {
+		t.Fatalf("expected no failures, got %v", failures)
+	}
+
+	failing := map[string]Matcher{
+		"data.id":     {NotEquals: &equals},
+		"data.total":  {LT: &gt},
+		"data.active": {Exists: &no},
+		"data.items":  {Length: &wrongLength},
+	}
+	failures := evaluateExpectations(payload, failing, vars)
+	if len(failures) != 4 {
+		t.Fatalf("expected 4 failures, got %d (%v)", len(failures), failures)
+	}
+	if failures[0].Path != "data.active" {
+		t.Fatalf("expected failures sorted by path, got %v", failures)
+	}
+}
+
+func TestMatcherScalarShorthand(t *testing.T) {
+	var step Step
+	input := `
+name: check
+expect:
+  data.status: active
+  data.count:
+    gt: 1
+`
+	if err := yaml.Unmarshal([]byte(input), &step); err != nil {
+		t.Fatalf("unmarshal step: %v", err)
+	}
+
+	status := step.Expect["data.status"]
+	if status.Equals == nil || *status.Equals != "active" {
+		t.Fatalf("expected scalar shorthand to set equals, got %+v", status)
+	}
+	count := step.Expect["data.count"]
+	if count.GT == nil || *count.GT != 1 {
+		t.Fatalf("expected gt matcher, got %+v", count)
+	}
+}
+
+func TestValidateExpectationsReportsEveryFailure(t *testing.T) {
+	runner := &FlowRunner{}
+	want := "pending"
+	step := Step{
+		Name: "assert-step",
+		Expect: map[string]Matcher{
+			"status": {Equals: &want},
+			"id":     {Type: "number"},
+		},
+	}
+
+	err := runner.validateExpectations(step, []byte(`{"status":"done","id":"abc"}`), map[string]string{}, "response")
+	if err == nil {
+		t.Fatalf("expected expectation error")
+	}
+	for _, fragment := range []string{`status: expected "pending", got "done"`, "id: expected type number, got type string"} {
+		if !strings.Contains(err.Error(), fragment) {
+			t.Fatalf("expected error to contain %q, got %q", fragment, err.Error())
+		}
+	}
+}
+
+func TestExecuteStepRetriesUntilConditionMet(t *testing.T) {
+	calls := 0
+	runner := &FlowRunner{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				state := "pending"
+				if calls >= 3 {
+					state = "ready"
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"state":"` + state + `"}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	ready := "ready"
+	step := Step{
+		Name:   "poll-job",
+		Method: http.MethodGet,
+		URL:    "http://example.test/jobs/1",
+		Save:   map[string]string{"state": "state"},
+		Retry: &RetryPolicy{
+			Attempts: 5,
+			Interval: "1ms",
+			Until: &RetryUntil{
+				Status: http.StatusOK,
+				Body:   map[string]Matcher{"state": {Equals: &ready}},
+			},
+		},
+	}
+
+	vars := map[string]string{}
+	if err := runner.executeStep(context.Background(), step, vars); err != nil {
+		t.Fatalf("executeStep: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+	if vars["state"] != "ready" {
+		t.Fatalf("expected saved state from final attempt, got %q", vars["state"])
+	}
+
+	calls = 0
+	step.Retry.Attempts = 2
+	if err := runner.executeStep(context.Background(), step, vars); err == nil {
+		t.Fatalf("expected error once attempts are exhausted")
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestRetryPolicySchedule(t *testing.T) {
+	sched, err := (&RetryPolicy{Interval: "{{.every}}", MaxElapsed: "1m", Backoff: 0.5}).schedule(map[string]string{"every": "250ms"})
+	if err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	if sched.interval != 250*time.Millisecond || sched.maxElapsed != time.Minute {
+		t.Fatalf("unexpected schedule: %+v", sched)
+	}
+	if sched.attempts != 0 || sched.backoff != 1 {
+		t.Fatalf("expected unbounded attempts and backoff clamped to 1, got %+v", sched)
+	}
+
+	if _, err := (&RetryPolicy{Interval: "soon"}).schedule(nil); err == nil {
+		t.Fatalf("expected error for invalid interval")
+	}
+}
+
+func TestRunFlowContinueOnError(t *testing.T) {
+	dir := t.TempDir()
+	fragmentYAML := `steps:
+  - name: purge
+    method: POST
+    url: http://example.test/purge
+    expect_status: 204
+`
+	if err := os.WriteFile(filepath.Join(dir, "purge.yaml"), []byte(fragmentYAML), filePermission); err != nil {
+		t.Fatalf("write fragment: %v", err)
+	}
+
+	flowYAML := `steps:
+  - name: best-effort
+    continue_on_error: true
+    method: GET
+    url: http://example.test/broken
+    expect_status: 200
+  - name: cleanup
+    use: purge
+    continue_on_error: true
+  - name: healthy
+    method: GET
+    url: http://example.test/healthy
+    expect_status: 200
+  - name: fatal
+    method: GET
+    url: http://example.test/broken
+    expect_status: 200
+  - name: never
+    method: GET
+    url: http://example.test/never
+`
+	flowFile := filepath.Join(dir, "continue.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var paths []string
+	runner := &FlowRunner{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				paths = append(paths, req.URL.Path)
+				status := http.StatusOK
+				if req.URL.Path != "/healthy" {
+					status = http.StatusInternalServerError
+				}
+				return &http.Response{
+					StatusCode: status,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Status != flowStatusFail {
+		t.Fatalf("expected failing flow, got %q", result.Status)
+	}
+	if want := []string{"/broken", "/purge", "/healthy", "/broken"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expected requests %v, got %v", want, paths)
+	}
+
+	passed, failed, _ := result.stepCounts()
+	if passed != 1 || failed != 3 {
+		t.Fatalf("unexpected step counts: passed=%d failed=%d", passed, failed)
+	}
+}
+
+func TestRunFlowKeepGoing(t *testing.T) {
+	flowYAML := `steps:
+  - name: broken
+    method: GET
+    url: http://example.test/broken
+    expect_status: 200
+  - name: skipped
+    skip: true
+    method: GET
+    url: http://example.test/skipped
+  - name: healthy
+    method: GET
+    url: http://example.test/healthy
+    expect_status: 200
+`
+
+	flowFile := filepath.Join(t.TempDir(), "keep-going.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var paths []string
+	runner := &FlowRunner{
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				paths = append(paths, req.URL.Path)
+				status := http.StatusOK
+				if req.URL.Path == "/broken" {
+					status = http.StatusInternalServerError
+				}
+				return &http.Response{
+					StatusCode: status,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err == nil || len(result.Steps) != 1 {
+		t.Fatalf("expected fail-fast after first step, got %+v", result)
+	}
+
+	paths = nil
+	runner.keepGoing = true
+	result = runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Status != flowStatusFail {
+		t.Fatalf("expected failing flow, got %q", result.Status)
+	}
+	if len(paths) != 2 || paths[1] != "/healthy" {
+		t.Fatalf("expected remaining steps to run, got %v", paths)
+	}
+
+	passed, failed, skipped := result.stepCounts()
+	if passed != 1 || failed != 1 || skipped != 1 {
+		t.Fatalf("unexpected step counts: passed=%d failed=%d skipped=%d", passed, failed, skipped)
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	results := []flowResult{
+		{Name: "001_ok", Status: flowStatusPass, Steps: []stepResult{{Status: stepStatusSuccess}}},
+		{Name: "002_bad", Status: flowStatusFail, Err: errors.New("boom"), Steps: []stepResult{{Status: stepStatusError}}},
+		{Name: "003_rest", Status: flowStatusSkip},
+	}
+
+	var buf strings.Builder
+	printRunSummary(&buf, results)
+
+	out := buf.String()
+	for _, fragment := range []string{"001_ok", "PASS", "FAIL", "SKIP", "1 passed, 1 failed, 1 skipped", "002_bad: boom"} {
+		if !strings.Contains(out, fragment) {
+			t.Fatalf("summary missing %q:\n%s", fragment, out)
+		}
+	}
+
+	if err := summarizeFailures(results); err == nil || err.Error() != "1 of 3 flows failed" {
+		t.Fatalf("unexpected summary error: %v", err)
+	}
+	if err := summarizeFailures(results[:1]); err != nil {
+		t.Fatalf("expected no error for passing run, got %v", err)
+	}
+}
+
+func TestFlowStatusAllSkipped(t *testing.T) {
+	result := flowResult{Steps: []stepResult{{Status: stepStatusSkipped}, {Status: stepStatusSkipped}}}
+	if got := result.flowStatus(); got != flowStatusSkip {
+		t.Fatalf("expected skip, got %q", got)
+	}
+}
+
+func TestRunTargetsParallelBuffersOutput(t *testing.T) {
+	dir := t.TempDir()
+
+	var targets []FlowFile
+	for _, name := range []string{"001_alpha", "002_beta", "003_gamma"} {
+		flowYAML := `vars:
+  flow: ` + name + `
+steps:
+  - name: first-` + name + `
+    method: GET
+    url: "http://example.test/{{.flow}}/1"
+    expect_status: 200
+  - name: second-` + name + `
+    method: GET
+    url: "http://example.test/{{.flow}}/2"
+    expect_status: 200
+`
+		path := filepath.Join(dir, name+".yaml")
+		if err := os.WriteFile(path, []byte(flowYAML), filePermission); err != nil {
+			t.Fatalf("write flow file: %v", err)
+		}
+		targets = append(targets, FlowFile{Name: name, Path: path})
+	}
+
+	var buf strings.Builder
+	runner := &FlowRunner{
+		out: &buf,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				time.Sleep(5 * time.Millisecond)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	results := runner.runTargets(context.Background(), targets, nil, 3)
+	for idx, result := range results {
+		if result.Status != flowStatusPass || result.Name != targets[idx].Name {
+			t.Fatalf("unexpected result %d: %+v", idx, result)
+		}
+	}
+
+	out := buf.String()
+	for _, target := range targets {
+		header := strings.Index(out, "=== Flow: "+target.Name)
+		first := strings.Index(out, "first-"+target.Name)
+		second := strings.LastIndex(out, "second-"+target.Name)
+		if header < 0 || first < header || second < first {
+			t.Fatalf("missing output for %s:\n%s", target.Name, out)
+		}
+		if next := strings.Index(out[header+1:], "=== Flow: "); next >= 0 && header+1+next < second {
+			t.Fatalf("output for %s interleaved with another flow:\n%s", target.Name, out)
+		}
+	}
+}
+
+func TestRunTargetsStopsAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "001_bad.yaml")
+	if err := os.WriteFile(bad, []byte("steps:\n  - name: broken\n"), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+	good := writeFlowFile(t, dir, "002_good.yaml")
+
+	runner := &FlowRunner{out: io.Discard}
+	targets := []FlowFile{{Name: "001_bad", Path: bad}, {Name: "002_good", Path: good}}
+
+	results := runner.runTargets(context.Background(), targets, nil, 1)
+	if results[0].Status != flowStatusFail || results[1].Status != flowStatusSkip {
+		t.Fatalf("expected fail then skip, got %q and %q", results[0].Status, results[1].Status)
+	}
+
+	runner.keepGoing = true
+	results = runner.runTargets(context.Background(), targets, nil, 1)
+	if results[1].Status != flowStatusPass {
+		t.Fatalf("expected second flow to run with keepGoing, got %q", results[1].Status)
+	}
+}
+
+func TestParseReportSpecs(t *testing.T) {
+	specs, err := parseReportSpecs([]string{"junit=out/junit.xml", " JSON = out/report.json"})
+	if err != nil {
+		t.Fatalf("parseReportSpecs: %v", err)
+	}
+	if len(specs) != 2 || specs[0].Format != reportFormatJUnit || specs[1].Format != reportFormatJSON || specs[1].Path != "out/report.json" {
+		t.Fatalf("unexpected specs: %+v", specs)
+	}
+
+	for _, bad := range []string{"junit", "html=out.html", "json="} {
+		if _, err := parseReportSpecs([]string{bad}); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestWriteReports(t *testing.T) {
+	results := []flowResult{
+		{
+			Name:     "001_checkout",
+			Path:     "flows/001_checkout.yaml",
+			Status:   flowStatusFail,
+			Duration: 1500 * time.Millisecond,
+			Err:      errors.New(`step "pay" failed: unexpected status 500`),
+			Steps: []stepResult{
+				{Name: "cart", Type: "http", Status: stepStatusSuccess, Duration: 500 * time.Millisecond, Request: map[string]any{"url": "http://example.test/cart"}},
+				{Name: "pay", Type: "http", Status: stepStatusError, Duration: time.Second, Err: errors.New(`step "pay" failed: unexpected status 500`)},
+				{Name: "cleanup", Type: "sql", Status: stepStatusSkipped},
+			},
+		},
+		{Name: "002_refund", Path: "flows/002_refund.yaml", Status: flowStatusSkip},
+	}
+
+	dir := t.TempDir()
+	specs := []reportSpec{
+		{Format: reportFormatJUnit, Path: filepath.Join(dir, "reports", "junit.xml")},
+		{Format: reportFormatJSON, Path: filepath.Join(dir, "reports", "report.json")},
+	}
+	if err := writeReports(specs, results); err != nil {
+		t.Fatalf("writeReports: %v", err)
+	}
+
+	junitData, err := os.ReadFile(specs[0].Path)
+	if err != nil {
+		t.Fatalf("read junit report: %v", err)
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(junitData, &suites); err != nil {
+		t.Fatalf("unmarshal junit report: %v", err)
+	}
+	if suites.Tests != 4 || suites.Failures != 1 || suites.Skipped != 2 || len(suites.Suites) != 2 {
+		t.Fatalf("unexpected junit totals: %+v", suites)
+	}
+	pay := suites.Suites[0].Cases[1]
+	if pay.Failure == nil || !strings.Contains(pay.Failure.Message, "unexpected status 500") || pay.Time != "1.000" {
+		t.Fatalf("unexpected failing testcase: %+v", pay)
+	}
+	if !strings.Contains(suites.Suites[0].Cases[0].SystemOut, "http://example.test/cart") {
+		t.Fatalf("expected request captured in system-out, got %q", suites.Suites[0].Cases[0].SystemOut)
+	}
+
+	jsonData, err := os.ReadFile(specs[1].Path)
+	if err != nil {
+		t.Fatalf("read json report: %v", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(jsonData, &report); err != nil {
+		t.Fatalf("unmarshal json report: %v", err)
+	}
+	if report.Failed != 1 || report.Skipped != 1 || len(report.Flows[0].Steps) != 3 {
+		t.Fatalf("unexpected json report: %+v", report)
+	}
+	if report.Flows[0].Steps[1].Error == "" || report.Flows[0].Steps[0].DurationMillis != 500 {
+		t.Fatalf("unexpected json steps: %+v", report.Flows[0].Steps)
+	}
+}
+
+func TestReportsRedactSensitiveHeaders(t *testing.T) {
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		signature = req.Header.Get("X-Api-Sig")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "server-session-secret"})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	flowYAML := `steps:
+  - name: signed
+    method: POST
+    url: ` + server.URL + `/orders
+    headers:
+      Cookie: sid=client-cookie-secret
+      Proxy-Authorization: Basic cHJveHktc2VjcmV0
+      X-Request-Id: req-123
+    body: '{"id":1}'
+    sign:
+      type: hmac
+      secret: hmac-key
+      header: X-Api-Sig
+`
+	flowFile := filepath.Join(dir, "signed.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	runner, err := newFlowRunner("", "", true)
+	if err != nil {
+		t.Fatalf("newFlowRunner: %v", err)
+	}
+	runner.out = io.Discard
+	defer runner.Close()
+
+	results := runner.runFlow(context.Background(), flowFile, nil)
+	if results[0].Err != nil {
+		t.Fatalf("run flow: %v", results[0].Err)
+	}
+
+	report := writeTestReports(t, dir, results)
+	for _, secret := range []string{"client-cookie-secret", "cHJveHktc2VjcmV0", "server-session-secret", signature} {
+		if strings.Contains(report, secret) {
+			t.Fatalf("expected %q to be redacted from reports:\n%s", secret, report)
+		}
+	}
+	if !strings.Contains(report, "req-123") || !strings.Contains(report, redactedValue) {
+		t.Fatalf("expected other headers kept and secrets marked redacted:\n%s", report)
+	}
+}
+
+// writeTestReports writes JUnit and JSON reports for results and returns
+// their combined contents.
+func writeTestReports(t *testing.T, dir string, results []flowResult) string {
+	t.Helper()
+
+	specs := []reportSpec{
+		{Format: reportFormatJUnit, Path: filepath.Join(dir, "reports", "junit.xml")},
+		{Format: reportFormatJSON, Path: filepath.Join(dir, "reports", "report.json")},
+	}
+	if err := writeReports(specs, results); err != nil {
+		t.Fatalf("writeReports: %v", err)
+	}
+
+	var combined strings.Builder
+	for _, spec := range specs {
+		data, err := os.ReadFile(spec.Path)
+		if err != nil {
+			t.Fatalf("read report: %v", err)
+		}
+		combined.Write(data)
+	}
+	return combined.String()
+}
+
+func TestRunStepCapturesExchangeWithoutLogger(t *testing.T) {
+	runner := &FlowRunner{
+		captureSteps: true,
+		out:          io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"ok":true}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	result := runner.runStep(context.Background(), Step{Name: "ping", Method: http.MethodGet, URL: "http://example.test/ping"}, map[string]string{})
+	if result.Err != nil {
+		t.Fatalf("runStep: %v", result.Err)
+	}
+	if result.Type != "http" || result.Request["url"] != "http://example.test/ping" || result.Response["status"] != http.StatusOK {
+		t.Fatalf("expected captured exchange, got %+v", result)
+	}
+}
+
+func TestLoadFlowFileIncludesAndFragments(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "shared", "auth"), dirPermission); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	files := map[string]string{
+		"shared/common.yaml": `vars:
+  base: http://common.test
+  tenant: default
+fragments:
+  create-tenant:
+    vars:
+      tenant_name: acme
+    steps:
+      - name: create
+        method: POST
+        url: "{{.base}}/tenants"
+        body: '{"name":"{{.tenant_name}}"}'
+`,
+		"shared/auth/login.yaml": `vars:
+  password: secret
+steps:
+  - name: login
+    method: POST
+    url: "{{.base}}/login"
+    body: '{"email":"{{.email}}","password":"{{.password}}"}'
+  - name: me
+    method: GET
+    url: "{{.base}}/me"
+`,
+		"flow.yaml": `include:
+  - shared/common.yaml
+vars:
+  tenant: override
+steps:
+  - use: shared/auth/login
+    with:
+      email: "{{.tenant}}@example.com"
+  - name: setup
+    use: create-tenant
+    with:
+      tenant_name: globex
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), filePermission); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	flow, err := loadFlowFile(filepath.Join(dir, "flow.yaml"))
+	if err != nil {
+		t.Fatalf("loadFlowFile: %v", err)
+	}
+
+	if flow.Vars["base"] != "http://common.test" || flow.Vars["tenant"] != "override" {
+		t.Fatalf("unexpected merged vars: %v", flow.Vars)
+	}
+
+	var names []string
+	for _, group := range flow.Steps {
+		for _, step := range group.Steps {
+			names = append(names, step.Name)
+		}
+	}
+	want := []string{"shared/auth/login/login", "shared/auth/login/me", "setup/create"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected steps %v, got %v", want, names)
+	}
+
+	login := flow.Steps[0]
+	if login.With["email"] != "{{.tenant}}@example.com" || login.With["password"] != "secret" {
+		t.Fatalf("expected fragment params on the use step, got %v", login.With)
+	}
+	if len(login.Steps[0].With) != 0 {
+		t.Fatalf("expected no params on fragment steps, got %v", login.Steps[0].With)
+	}
+	if flow.Steps[1].With["tenant_name"] != "globex" {
+		t.Fatalf("expected with to override fragment default, got %v", flow.Steps[1].With)
+	}
+}
+
+func TestRunFlowFragmentParamsAreScoped(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  base: http://example.test
+  tenant: flow-tenant
+fragments:
+  login:
+    vars:
+      tenant: fragment-tenant
+    steps:
+      - name: post
+        method: POST
+        url: "{{.base}}/login/{{.tenant}}/{{.email}}"
+        save:
+          token: token
+steps:
+  - use: login
+    with:
+      email: a@example.com
+  - name: after
+    method: GET
+    url: "{{.base}}/after/{{.tenant}}/{{.email}}/{{.token}}"
+  - name: skipped
+    use: login
+    if: "false"
+    with:
+      email: skipped@example.com
+  - name: last
+    method: GET
+    url: "{{.base}}/last/{{.tenant}}/{{.email}}"
+`
+	flowFile := filepath.Join(dir, "flow.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var paths []string
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				paths = append(paths, req.URL.Path)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"token":"t-1"}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err != nil {
+		t.Fatalf("run flow: %v", result.Err)
+	}
+
+	want := []string{
+		"/login/fragment-tenant/a@example.com",
+		"/after/flow-tenant//t-1",
+		"/last/flow-tenant/",
+	}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected requests %v, got %v", want, paths)
+	}
+	if len(result.Steps) != 4 || result.Steps[2].Status != stepStatusSkipped || result.Steps[2].Name != "skipped/post" {
+		t.Fatalf("expected the skipped use to record its fragment step, got %+v", result.Steps)
+	}
+}
+
+func TestLoadFlowFileRejectsFragmentSetup(t *testing.T) {
+	dir := t.TempDir()
+	fragment := "setup:\n  - name: seed\n    sql: SELECT 1\nsteps:\n  - name: ping\n    method: GET\n    url: http://example.test\n"
+	if err := os.WriteFile(filepath.Join(dir, "ping.yaml"), []byte(fragment), filePermission); err != nil {
+		t.Fatalf("write fragment: %v", err)
+	}
+	flowFile := filepath.Join(dir, "flow.yaml")
+	if err := os.WriteFile(flowFile, []byte("steps:\n  - use: ping\n"), filePermission); err != nil {
+		t.Fatalf("write flow: %v", err)
+	}
+
+	if _, err := loadFlowFile(flowFile); err == nil || !strings.Contains(err.Error(), "cannot declare setup or teardown") {
+		t.Fatalf("expected setup rejection, got %v", err)
+	}
+}
+
+func TestLoadFlowFileIncludeAndUseSameFile(t *testing.T) {
+	dir := t.TempDir()
+	shared := `fragments:
+  ping:
+    steps:
+      - name: get
+        method: GET
+        url: http://example.test/ping
+steps:
+  - name: health
+    method: GET
+    url: http://example.test/health
+`
+	if err := os.WriteFile(filepath.Join(dir, "shared.yaml"), []byte(shared), filePermission); err != nil {
+		t.Fatalf("write shared: %v", err)
+	}
+	flowFile := filepath.Join(dir, "flow.yaml")
+	flowYAML := "include:\n  - shared.yaml\nsteps:\n  - use: ping\n  - name: again\n    use: shared\n"
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow: %v", err)
+	}
+
+	flow, err := loadFlowFile(flowFile)
+	if err != nil {
+		t.Fatalf("load flow: %v", err)
+	}
+
+	var names []string
+	for _, step := range flow.Steps {
+		names = append(names, step.Name)
+		for _, child := range step.Steps {
+			names = append(names, child.Name)
+		}
+	}
+	want := []string{"health", "ping", "ping/get", "again", "again/health"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("expected steps %v, got %v", want, names)
+	}
+
+	other := filepath.Join(dir, "other.yaml")
+	if err := os.WriteFile(other, []byte("fragments:\n  ping:\n    steps: []\n"), filePermission); err != nil {
+		t.Fatalf("write other: %v", err)
+	}
+	if err := os.WriteFile(flowFile, []byte("include:\n  - shared.yaml\n  - other.yaml\n"), filePermission); err != nil {
+		t.Fatalf("write flow: %v", err)
+	}
+	if _, err := loadFlowFile(flowFile); err == nil || !strings.Contains(err.Error(), `fragment "ping" defined more than once`) {
+		t.Fatalf("expected duplicate fragment error, got %v", err)
+	}
+}
+
+func TestLoadFlowFileDetectsCycles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.yaml")
+	b := filepath.Join(dir, "b.yaml")
+	if err := os.WriteFile(a, []byte("steps:\n  - use: b\n"), filePermission); err != nil {
+		t.Fatalf("write a: %v", err)
+	}
+	if err := os.WriteFile(b, []byte("steps:\n  - use: a\n"), filePermission); err != nil {
+		t.Fatalf("write b: %v", err)
+	}
+
+	if _, err := loadFlowFile(a); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+
+	if err := os.WriteFile(a, []byte("steps:\n  - use: missing\n"), filePermission); err != nil {
+		t.Fatalf("write a: %v", err)
+	}
+	if _, err := loadFlowFile(a); err == nil || !strings.Contains(err.Error(), `fragment "missing" not found`) {
+		t.Fatalf("expected missing fragment error, got %v", err)
+	}
+}
+
+func TestApplyStepParams(t *testing.T) {
+	vars := map[string]string{"tenant": "acme"}
+	applyStepParams(Step{With: map[string]string{"email": "admin@{{.tenant}}.test", "tenant": "globex"}}, vars)
+
+	if vars["email"] != "admin@acme.test" || vars["tenant"] != "globex" {
+		t.Fatalf("expected params rendered against previous vars, got %v", vars)
+	}
+}
+
+func TestRunFlowSetupAndTeardown(t *testing.T) {
+	flowYAML := `setup:
+  - name: create-user
+    method: POST
+    url: http://example.test/users
+    expect_status: 201
+    save:
+      user_id: id
+steps:
+  - name: broken
+    method: GET
+    url: http://example.test/broken
+    expect_status: 200
+  - name: never-runs
+    method: GET
+    url: http://example.test/never
+teardown:
+  - name: delete-user
+    method: DELETE
+    url: "http://example.test/users/{{.user_id}}"
+`
+
+	flowFile := filepath.Join(t.TempDir(), "teardown.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var calls []string
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if err := req.Context().Err(); err != nil {
+					return nil, err
+				}
+				calls = append(calls, req.Method+" "+req.URL.Path)
+				status := http.StatusOK
+				switch req.URL.Path {
+				case "/users":
+					status = http.StatusCreated
+				case "/broken":
+					status = http.StatusInternalServerError
+				}
+				return &http.Response{
+					StatusCode: status,
+					Body:       io.NopCloser(strings.NewReader(`{"id":"u-1"}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Status != flowStatusFail {
+		t.Fatalf("expected failing flow, got %q", result.Status)
+	}
+
+	want := []string{"POST /users", "GET /broken", "DELETE /users/u-1"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+
+	t.Run("failed setup records main steps as skipped", func(t *testing.T) {
+		calls = nil
+		setupFile := filepath.Join(t.TempDir(), "setup.yaml")
+		failing := strings.Replace(flowYAML, "url: http://example.test/users\n", "url: http://example.test/broken\n", 1)
+		if err := os.WriteFile(setupFile, []byte(failing), filePermission); err != nil {
+			t.Fatalf("write flow file: %v", err)
+		}
+
+		result := runner.withKeepGoing(true).runFlow(context.Background(), setupFile, nil)[0]
+		if result.Err == nil {
+			t.Fatalf("expected setup failure")
+		}
+		if len(calls) != 2 || calls[0] != "POST /broken" || calls[1] != "DELETE /users/" {
+			t.Fatalf("expected setup then teardown only, got %v", calls)
+		}
+
+		var skipped []string
+		for _, step := range result.Steps {
+			if step.Status == stepStatusSkipped && step.SkipReason == "setup failed" {
+				skipped = append(skipped, step.Name)
+			}
+		}
+		if strings.Join(skipped, ",") != "broken,never-runs" {
+			t.Fatalf("expected main steps recorded as skipped, got %v", result.Steps)
+		}
+	})
+
+	t.Run("cancelled context still tears down", func(t *testing.T) {
+		calls = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		result := runner.runFlow(ctx, flowFile, nil)[0]
+		if result.Err == nil {
+			t.Fatalf("expected interrupted flow to fail")
+		}
+		if len(calls) != 1 || calls[0] != "DELETE /users/" {
+			t.Fatalf("expected only teardown to run, got %v", calls)
+		}
+	})
+}
+
+func TestMatrixLoad(t *testing.T) {
+	dir := t.TempDir()
+	csvData := "email, role\nann@example.test,admin\nbob@example.test,\"viewer, guest\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "users.csv"), []byte(csvData), filePermission); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	jsonData := `[{"id": 7, "active": true, "tags": ["a"], "note": null}]`
+	if err := os.WriteFile(filepath.Join(dir, "items.json"), []byte(jsonData), filePermission); err != nil {
+		t.Fatalf("write json: %v", err)
+	}
+
+	var matrix Matrix
+	if err := yaml.Unmarshal([]byte(`"{{.set}}.csv"`), &matrix); err != nil {
+		t.Fatalf("unmarshal matrix: %v", err)
+	}
+
+	rows, err := matrix.load(dir, map[string]string{"set": "users"})
+	if err != nil {
+		t.Fatalf("load csv matrix: %v", err)
+	}
+	if len(rows) != 2 || rows[1].Vars["role"] != "viewer, guest" || rows[0].Vars["email"] != "ann@example.test" {
+		t.Fatalf("unexpected csv rows: %+v", rows)
+	}
+	if rows[0].Label != "email=ann@example.test,role=admin" {
+		t.Fatalf("unexpected label %q", rows[0].Label)
+	}
+
+	matrix = Matrix{Rows: []map[string]string{{"id": "1"}}, File: "items.json"}
+	rows, err = matrix.load(dir, nil)
+	if err != nil {
+		t.Fatalf("load json matrix: %v", err)
+	}
+	want := map[string]string{"id": "7", "active": "true", "tags": `["a"]`, "note": ""}
+	if len(rows) != 2 || rows[0].Vars["id"] != "1" || !reflect.DeepEqual(rows[1].Vars, want) {
+		t.Fatalf("unexpected json rows: %+v", rows)
+	}
+
+	if _, err := (&Matrix{File: "items.txt"}).load(dir, nil); err == nil {
+		t.Fatalf("expected unsupported extension error")
+	}
+	if _, err := (&Matrix{}).load(dir, nil); err == nil {
+		t.Fatalf("expected empty matrix error")
+	}
+}
+
+func TestRunFlowMatrixScopesRowVars(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "prod.csv"), []byte("currency\nEUR\n"), filePermission); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+
+	flowYAML := `vars:
+  set: staging
+  id: flow-id
+matrix:
+  file: "{{.set}}.csv"
+steps:
+  - name: pay
+    method: GET
+    url: "http://example.test/{{.currency}}/{{.id}}/{{.method}}"
+    matrix:
+      - {id: "1", method: card}
+      - {id: "2"}
+  - name: after
+    method: GET
+    url: "http://example.test/after/{{.id}}/{{.method}}"
+`
+	flowFile := filepath.Join(dir, "pay.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var paths []string
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				paths = append(paths, req.URL.Path)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	results := runner.runFlow(context.Background(), flowFile, map[string]string{"set": "prod"})
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("expected the --var override to pick prod.csv, got %+v", results)
+	}
+
+	want := []string{"/EUR/1/card", "/EUR/2/", "/after/flow-id/"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected requests %v, got %v", want, paths)
+	}
+}
+
+func TestRunFlowMatrix(t *testing.T) {
+	flowYAML := `matrix:
+  - region: eu
+  - region: us
+steps:
+  - name: list
+    method: GET
+    url: "http://example.test/{{.region}}/items/{{.id}}"
+    expect_status: 200
+    matrix:
+      - id: "1"
+      - id: "2"
+`
+
+	flowFile := filepath.Join(t.TempDir(), "matrix.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var calls []string
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, req.URL.Path)
+				status := http.StatusOK
+				if req.URL.Path == "/us/items/1" {
+					status = http.StatusNotFound
+				}
+				return &http.Response{
+					StatusCode: status,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	results := runner.runFlow(context.Background(), flowFile, nil)
+	if len(results) != 2 {
+		t.Fatalf("expected one result per row, got %d", len(results))
+	}
+	if results[0].Iteration != "region=eu" || results[0].Status != flowStatusPass {
+		t.Fatalf("unexpected first iteration: %+v", results[0])
+	}
+	if len(results[0].Steps) != 2 || results[0].Steps[1].Name != "list[id=2]" {
+		t.Fatalf("expected step iterations, got %+v", results[0].Steps)
+	}
+	if results[1].Status != flowStatusFail || len(results[1].Steps) != 1 {
+		t.Fatalf("expected second iteration to stop at first failing row, got %+v", results[1])
+	}
+
+	want := []string{"/eu/items/1", "/eu/items/2", "/us/items/1"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+
+	t.Run("keep going runs every row", func(t *testing.T) {
+		calls = nil
+		results := runner.withKeepGoing(true).runFlow(context.Background(), flowFile, nil)
+		if len(calls) != 4 || len(results[1].Steps) != 2 {
+			t.Fatalf("expected every row to run, got calls %v", calls)
+		}
+	})
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	vars := map[string]string{"env": "staging", "flag": "off", "count": "3"}
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`{{eq .env "staging"}}`, true},
+		{`eq .env "prod"`, false},
+		{`.flag`, false},
+		{`.missing`, false},
+		{`{{.count}}`, true},
+		{`and (ne .env "prod") (eq .count "3")`, true},
+		{`true`, true},
+		{`false`, false},
+	}
+
+	for _, tc := range cases {
+		got, err := evaluateCondition(tc.expr, vars)
+		if err != nil {
+			t.Fatalf("evaluate %q: %v", tc.expr, err)
+		}
+		if got != tc.want {
+			t.Fatalf("evaluate %q: expected %v, got %v", tc.expr, tc.want, got)
+		}
+	}
+
+	if _, err := evaluateCondition(`{{eq .env`, vars); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
+
+func TestInterruptSkipsWaitThenCancels(t *testing.T) {
+	waits := newWaitSkipper()
+	if waits.skip() {
+		t.Fatalf("expected no wait to skip")
+	}
+
+	ctx, cancel := handleInterrupts(context.Background(), waits)
+	defer cancel()
+
+	runner := &FlowRunner{out: io.Discard, waits: waits}
+	step := Step{Name: "settle", Wait: "1m", SQL: "SELECT 1", DatabaseURL: "sqlite::memory:"}
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := runner.executeStepBody(ctx, step, map[string]string{}, nil)
+		done <- err
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		waits.mu.Lock()
+		waiting := len(waits.waits)
+		waits.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("wait never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("find process: %v", err)
+	}
+
+	if err := self.Signal(os.Interrupt); err != nil {
+		t.Fatalf("send interrupt: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the step to run after the skipped wait, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected Ctrl-C to skip the wait")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("expected the run to continue after skipping a wait")
+	}
+
+	if err := self.Signal(os.Interrupt); err != nil {
+		t.Fatalf("send interrupt: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected Ctrl-C outside a wait to cancel the run")
+	}
+}
+
+func TestRunStepIfRecordsSkipReason(t *testing.T) {
+	var calls int
+	runner := &FlowRunner{
+		out:    io.Discard,
+		logger: &runLogger{},
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	step := Step{Name: "flagged", Method: http.MethodGet, URL: "http://example.test", If: `eq .env "staging"`}
+
+	result := runner.runStep(context.Background(), step, map[string]string{"env": "prod"})
+	if result.Status != stepStatusSkipped || result.Err != nil || calls != 0 {
+		t.Fatalf("expected skipped step without a request, got %+v (calls %d)", result, calls)
+	}
+	if len(runner.logger.entries) != 1 || runner.logger.entries[0].Reason != `if "eq .env \"staging\"" is false` {
+		t.Fatalf("expected skip reason in log, got %+v", runner.logger.entries)
+	}
+
+	result = runner.runStep(context.Background(), step, map[string]string{"env": "staging"})
+	if result.Status != stepStatusSuccess || calls != 1 {
+		t.Fatalf("expected step to run, got %+v (calls %d)", result, calls)
+	}
+
+	group := Step{Name: "use", If: "false", With: map[string]string{"env": "prod"}, Steps: []Step{step}, fragment: "use"}
+	vars := map[string]string{"env": "staging"}
+	var flow flowResult
+	if errs := runner.runFragmentStep(context.Background(), group, vars, &flow); len(errs) != 0 || calls != 1 {
+		t.Fatalf("expected skipped fragment without a request, got %v (calls %d)", errs, calls)
+	}
+	if len(flow.Steps) != 1 || flow.Steps[0].Status != stepStatusSkipped || flow.Steps[0].SkipReason != `if "false" is false` {
+		t.Fatalf("expected the use step's if to skip fragment steps, got %+v", flow.Steps)
+	}
+	if vars["env"] != "staging" {
+		t.Fatalf("expected a skipped fragment to leave vars untouched, got %v", vars)
+	}
+}
+
+func TestRunFlowForeach(t *testing.T) {
+	flowYAML := `steps:
+  - name: list
+    method: GET
+    url: http://example.test/users
+    save:
+      users: data
+  - name: delete-each
+    foreach: users
+    steps:
+      - name: delete
+        method: DELETE
+        url: 'http://example.test/users/{{jsonPath .item "id"}}?n={{.index}}'
+  - name: touch-ids
+    foreach: users.#.id
+    steps:
+      - name: touch
+        method: POST
+        url: "http://example.test/touch/{{.item}}"
+`
+
+	flowFile := filepath.Join(t.TempDir(), "foreach.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var calls []string
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, req.Method+" "+req.URL.RequestURI())
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"data":[{"id":"a"},{"id":"b"}]}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err != nil {
+		t.Fatalf("run flow: %v", result.Err)
+	}
+
+	want := []string{
+		"GET /users",
+		"DELETE /users/a?n=0",
+		"DELETE /users/b?n=1",
+		"POST /touch/a",
+		"POST /touch/b",
+	}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+	if len(result.Steps) != 5 || result.Steps[2].Name != "delete-each[1]/delete" {
+		t.Fatalf("unexpected step results: %+v", result.Steps)
+	}
+}
+
+func TestResolveForeachItems(t *testing.T) {
+	vars := map[string]string{"users": `[{"id":1},{"id":2}]`, "name": "ann"}
+
+	items, err := resolveForeachItems("users.#.id", vars)
+	if err != nil || len(items) != 2 || items[1].String() != "2" {
+		t.Fatalf("unexpected items %v (err %v)", items, err)
+	}
+
+	items, err = resolveForeachItems(`["x","y","z"]`, vars)
+	if err != nil || len(items) != 3 {
+		t.Fatalf("unexpected literal items %v (err %v)", items, err)
+	}
+
+	if _, err := resolveForeachItems("name", vars); err == nil {
+		t.Fatalf("expected error for non-array var")
+	}
+	if _, err := resolveForeachItems("missing.#.id", vars); err == nil {
+		t.Fatalf("expected error for missing var")
+	}
+}
+
+func TestBuildRequestBodyFormAndMultipart(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "doc.pdf"), []byte("%PDF-binary"), filePermission); err != nil {
+		t.Fatalf("write upload: %v", err)
+	}
+	vars := map[string]string{"owner": "ann"}
+
+	var captured *http.Request
+	runner := &FlowRunner{
+		out:          io.Discard,
+		captureSteps: true,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				captured = req
+				if err := req.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+					return nil, err
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	upload := Step{
+		Name:   "upload",
+		Method: http.MethodPost,
+		URL:    "http://example.test/documents",
+		Multipart: []MultipartPart{
+			{Name: "owner", Value: "{{.owner}}"},
+			{Name: "file", File: "doc.pdf"},
+		},
+		dir: dir,
+	}
+
+	result := runner.runStep(context.Background(), upload, vars)
+	if result.Err != nil {
+		t.Fatalf("run upload step: %v", result.Err)
+	}
+	if got := captured.FormValue("owner"); got != "ann" {
+		t.Fatalf("expected owner field, got %q", got)
+	}
+	file, header, err := captured.FormFile("file")
+	if err != nil {
+		t.Fatalf("read uploaded file: %v", err)
+	}
+	data, _ := io.ReadAll(file)
+	if string(data) != "%PDF-binary" || header.Filename != "doc.pdf" || header.Header.Get("Content-Type") != "application/pdf" {
+		t.Fatalf("unexpected upload %q %+v", data, header.Header)
+	}
+
+	logged, _ := json.Marshal(result.Request["body"])
+	if strings.Contains(string(logged), "binary") || !strings.Contains(string(logged), `"size":11`) {
+		t.Fatalf("expected part summary without file data, got %s", logged)
+	}
+
+	form := Step{
+		Name:   "login",
+		Method: http.MethodPost,
+		URL:    "http://example.test/login",
+		Form:   map[string]string{"user": "{{.owner}}", "pass": "a&b"},
+	}
+	if result := runner.runStep(context.Background(), form, vars); result.Err != nil {
+		t.Fatalf("run form step: %v", result.Err)
+	}
+	if captured.Header.Get("Content-Type") != formContentType || captured.PostFormValue("pass") != "a&b" || captured.PostFormValue("user") != "ann" {
+		t.Fatalf("unexpected form request: %v %v", captured.Header, captured.PostForm)
+	}
+
+	form.Body = "{}"
+	if _, err := buildRequestBody(form, vars); err == nil {
+		t.Fatalf("expected error when combining body and form")
+	}
+}
+
+func TestRunFlowCookieJar(t *testing.T) {
+	flowYAML := `steps:
+  - name: login
+    method: POST
+    url: http://example.test/login
+    save:
+      sid: cookie:session
+    expect_cookies:
+      session:
+        regex: "^abc"
+  - name: profile
+    method: GET
+    url: "http://example.test/profile?sid={{.sid}}"
+`
+
+	dir := t.TempDir()
+	flowFile := filepath.Join(dir, "cookies.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var sentCookies []string
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				header := make(http.Header)
+				if req.URL.Path == "/login" {
+					header.Add("Set-Cookie", "session=abc123; Path=/; Max-Age=3600")
+				} else {
+					sentCookies = append(sentCookies, req.Header.Get("Cookie")+" "+req.URL.RawQuery)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     header,
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err != nil {
+		t.Fatalf("run flow: %v", result.Err)
+	}
+	if len(sentCookies) != 1 || sentCookies[0] != "session=abc123 sid=abc123" {
+		t.Fatalf("expected session cookie to be sent and saved, got %v", sentCookies)
+	}
+
+	t.Run("disabled per flow", func(t *testing.T) {
+		sentCookies = nil
+		disabled := filepath.Join(dir, "no-cookies.yaml")
+		if err := os.WriteFile(disabled, []byte("cookies: false\n"+flowYAML), filePermission); err != nil {
+			t.Fatalf("write flow file: %v", err)
+		}
+
+		result := runner.runFlow(context.Background(), disabled, nil)[0]
+		if result.Err != nil {
+			t.Fatalf("run flow: %v", result.Err)
+		}
+		if len(sentCookies) != 1 || sentCookies[0] != " sid=abc123" {
+			t.Fatalf("expected no cookie header without a jar, got %v", sentCookies)
+		}
+	})
+
+	t.Run("persisted between runs", func(t *testing.T) {
+		cookiesFile := filepath.Join(dir, "state", "cookies.json")
+		jar, err := loadCookieJar(cookiesFile)
+		if err != nil {
+			t.Fatalf("load missing cookies file: %v", err)
+		}
+
+		first := *runner
+		first.cookies, first.cookiesFile = jar, cookiesFile
+		if result := first.runFlow(context.Background(), flowFile, nil)[0]; result.Err != nil {
+			t.Fatalf("run flow: %v", result.Err)
+		}
+		if err := first.Close(); err != nil {
+			t.Fatalf("save cookies: %v", err)
+		}
+
+		restored, err := loadCookieJar(cookiesFile)
+		if err != nil {
+			t.Fatalf("load cookies file: %v", err)
+		}
+		target, _ := http.NewRequest(http.MethodGet, "http://example.test/other", nil)
+		cookies := restored.Cookies(target.URL)
+		if len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value != "abc123" {
+			t.Fatalf("expected restored session cookie, got %v", cookies)
+		}
+	})
+}
+
+func TestExecuteHTTPStepSavesHeadersAndStatus(t *testing.T) {
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				header := make(http.Header)
+				header.Set("Location", "/users/42")
+				header.Add("Link", `</users?page=2>; rel="next"`)
+				header.Add("Link", `</users?page=9>; rel="last"`)
+				return &http.Response{
+					StatusCode: http.StatusCreated,
+					Body:       io.NopCloser(strings.NewReader("created")),
+					Header:     header,
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	yes, other := true, "/users/7"
+	step := Step{
+		Name:   "create",
+		Method: http.MethodPost,
+		URL:    "http://example.test/users",
+		Save: map[string]string{
+			"location": "header:Location",
+			"links":    "header:link",
+			"code":     "response:status",
+		},
+		ExpectHeaders: map[string]Matcher{
+			"location": {Regex: `^/users/\d+$`},
+			"ETag":     {Absent: &yes},
+		},
+	}
+
+	vars := map[string]string{}
+	if err := runner.executeStep(context.Background(), step, vars); err != nil {
+		t.Fatalf("execute step: %v", err)
+	}
+
+	want := map[string]string{
+		"location": "/users/42",
+		"links":    `</users?page=2>; rel="next", </users?page=9>; rel="last"`,
+		"code":     "201",
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Fatalf("expected vars %v, got %v", want, vars)
+	}
+
+	step.ExpectHeaders = map[string]Matcher{"Location": {Equals: &other}}
+	if err := runner.executeStep(context.Background(), step, vars); err == nil || !strings.Contains(err.Error(), "Location") {
+		t.Fatalf("expected header expectation failure, got %v", err)
+	}
+}
+
+func TestExecuteHTTPStepSavesBodyStatusField(t *testing.T) {
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusAccepted,
+					Body:       io.NopCloser(strings.NewReader(`{"status":"queued","job":{"status":"pending"}}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	step := Step{
+		Name:   "enqueue",
+		Method: http.MethodPost,
+		URL:    "http://example.test/jobs",
+		Save: map[string]string{
+			"state":     "status",
+			"job_state": "job.status",
+			"code":      "response:status",
+		},
+	}
+
+	vars := map[string]string{}
+	if err := runner.executeStep(context.Background(), step, vars); err != nil {
+		t.Fatalf("execute step: %v", err)
+	}
+
+	want := map[string]string{"state": "queued", "job_state": "pending", "code": "202"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Fatalf("expected vars %v, got %v", want, vars)
+	}
+}
+
+func TestOAuthTokenCache(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		fetches []string
+	)
+	release := make(chan struct{})
+	slowStarted := make(chan struct{})
+	runner := &FlowRunner{
+		out:    io.Discard,
+		tokens: newTokenCache(),
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if err := req.ParseForm(); err != nil {
+					return nil, err
+				}
+				clientID, secret := req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+				mu.Lock()
+				fetches = append(fetches, clientID+":"+secret)
+				mu.Unlock()
+
+				switch clientID {
+				case "slow":
+					close(slowStarted)
+					<-release
+				case "stalled":
+					<-req.Context().Done()
+					return nil, req.Context().Err()
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"access_token":"tok-` + secret + `"}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	auth := func(clientID, secret string) *AuthConfig {
+		return &AuthConfig{Type: authTypeClientCredentials, TokenURL: "http://auth.test/token", ClientID: clientID, ClientSecret: secret}
+	}
+
+	t.Run("secret is part of the key", func(t *testing.T) {
+		for _, secret := range []string{"one", "two", "one"} {
+			_, value, err := runner.withAuth(auth("app", secret)).authHeader(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("authHeader: %v", err)
+			}
+			if value != "Bearer tok-"+secret {
+				t.Fatalf("expected the token for secret %q, got %q", secret, value)
+			}
+		}
+		if len(fetches) != 2 {
+			t.Fatalf("expected one fetch per secret, got %v", fetches)
+		}
+	})
+
+	t.Run("slow fetch does not block other credentials", func(t *testing.T) {
+		slowDone := make(chan error, 1)
+		go func() {
+			_, _, err := runner.withAuth(auth("slow", "s")).authHeader(context.Background(), nil)
+			slowDone <- err
+		}()
+		<-slowStarted
+
+		fastDone := make(chan error, 1)
+		go func() {
+			_, _, err := runner.withAuth(auth("fast", "f")).authHeader(context.Background(), nil)
+			fastDone <- err
+		}()
+
+		select {
+		case err := <-fastDone:
+			if err != nil {
+				t.Fatalf("fast authHeader: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("fast token fetch blocked behind the slow one")
+		}
+
+		close(release)
+		if err := <-slowDone; err != nil {
+			t.Fatalf("slow authHeader: %v", err)
+		}
+	})
+
+	t.Run("grpc token fetch honours the step timeout", func(t *testing.T) {
+		step := Step{
+			Name:           "rpc",
+			GRPC:           &GRPCStep{Target: "grpc.test:443", Method: "svc.Service/Call"},
+			TimeoutSeconds: 1,
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- runner.withAuth(auth("stalled", "x")).executeGRPCStep(context.Background(), step, map[string]string{}, nil, nil)
+		}()
+
+		select {
+		case err := <-done:
+			if err == nil || !strings.Contains(err.Error(), "authenticate step") {
+				t.Fatalf("expected the token fetch to time out, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("token fetch ignored the step timeout")
+		}
+	})
+
+	t.Run("step header skips the token fetch", func(t *testing.T) {
+		before := len(fetches)
+		req := httptest.NewRequest(http.MethodGet, "http://api.test/", nil)
+		step := Step{Headers: map[string]string{"authorization": "Bearer mine"}}
+		injected, err := runner.withAuth(auth("unused", "u")).applyHTTPAuth(context.Background(), req, step, nil)
+		if err != nil || injected || len(fetches) != before {
+			t.Fatalf("expected no token fetch, got injected=%v err=%v fetches=%v", injected, err, fetches)
+		}
+	})
+}
+
+func TestAuthTokensStayOutOfReportsAndCookies(t *testing.T) {
+	var cookies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			http.SetCookie(w, &http.Cookie{Name: "edge", Value: "from-token-endpoint", Path: "/"})
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"oauth-secret-token","expires_in":3600}`))
+			return
+		}
+		cookies = append(cookies, req.Header.Get("Cookie"))
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	flows := map[string]string{
+		"oauth.yaml": `auth:
+  type: client_credentials
+  token_url: ` + server.URL + `/token
+  client_id: app
+  client_secret: app-secret
+steps:
+  - name: orders
+    method: GET
+    url: ` + server.URL + `/orders
+`,
+		"bearer.yaml": `auth:
+  type: bearer
+  token: static-bearer-secret
+  header: X-Api-Token
+steps:
+  - name: orders
+    method: GET
+    url: ` + server.URL + `/orders
+`,
+	}
+
+	runner, err := newFlowRunner("", "", true)
+	if err != nil {
+		t.Fatalf("newFlowRunner: %v", err)
+	}
+	runner.out = io.Discard
+	defer runner.Close()
+
+	var results []flowResult
+	for _, name := range []string{"oauth.yaml", "bearer.yaml"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(flows[name]), filePermission); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		flowResults := runner.runFlow(context.Background(), path, nil)
+		if flowResults[0].Err != nil {
+			t.Fatalf("run %s: %v", name, flowResults[0].Err)
+		}
+		results = append(results, flowResults...)
+	}
+
+	report := writeTestReports(t, dir, results)
+	for _, secret := range []string{"oauth-secret-token", "static-bearer-secret", "app-secret"} {
+		if strings.Contains(report, secret) {
+			t.Fatalf("expected %q to stay out of reports:\n%s", secret, report)
+		}
+	}
+	for _, cookie := range cookies {
+		if cookie != "" {
+			t.Fatalf("expected token endpoint cookies to stay out of the flow jar, got %q", cookie)
+		}
+	}
+}
+
+func TestRunFlowOAuth2ClientCredentials(t *testing.T) {
+	flowYAML := `auth:
+  type: client_credentials
+  token_url: http://auth.test/token
+  client_id: "{{.client_id}}"
+  client_secret: s3cret
+  scopes: [read, write]
+vars:
+  client_id: flow-runner
+steps:
+  - name: first
+    method: GET
+    url: http://api.test/orders
+    expect_status: 200
+  - name: after-revocation
+    method: POST
+    url: http://api.test/orders
+    body: '{"sku":"a"}'
+    expect_status: 200
+  - name: explicit-header
+    method: GET
+    url: http://api.test/public
+    headers:
+      Authorization: none
+    expect_status: 200
+`
+
+	flowFile := filepath.Join(t.TempDir(), "auth.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var grants, seen []string
+	revoked := map[string]bool{}
+	runner := &FlowRunner{
+		out:    io.Discard,
+		tokens: newTokenCache(),
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				respond := func(status int, body string) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: status,
+						Body:       io.NopCloser(strings.NewReader(body)),
+						Header:     make(http.Header),
+						Request:    req,
+					}, nil
+				}
+
+				if req.URL.Host == "auth.test" {
+					if err := req.ParseForm(); err != nil {
+						return nil, err
+					}
+					grant := req.PostForm.Get("grant_type")
+					if grant == "client_credentials" && (req.PostForm.Get("client_id") != "flow-runner" || req.PostForm.Get("scope") != "read write") {
+						return respond(http.StatusBadRequest, `{"error":"invalid_client"}`)
+					}
+					grants = append(grants, grant)
+					token := fmt.Sprintf("tok-%d", len(grants))
+					return respond(http.StatusOK, `{"access_token":"`+token+`","token_type":"bearer","expires_in":3600,"refresh_token":"r1"}`)
+				}
+
+				auth := req.Header.Get("Authorization")
+				seen = append(seen, auth)
+				if req.Method == http.MethodPost {
+					if body, _ := io.ReadAll(req.Body); string(body) != `{"sku":"a"}` {
+						return respond(http.StatusBadRequest, `{}`)
+					}
+					revoked["Bearer tok-1"] = true
+				}
+				if revoked[auth] {
+					return respond(http.StatusUnauthorized, `{}`)
+				}
+				return respond(http.StatusOK, `{}`)
+			}),
+		},
+	}
+
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err != nil {
+		t.Fatalf("run flow: %v", result.Err)
+	}
+
+	if strings.Join(grants, ",") != "client_credentials,refresh_token" {
+		t.Fatalf("expected one fetch and one refresh, got %v", grants)
+	}
+	want := []string{"Bearer tok-1", "Bearer tok-1", "Bearer tok-2", "none"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected auth headers %v, got %v", want, seen)
+	}
+
+	t.Run("token cached across flows", func(t *testing.T) {
+		grants, seen = nil, nil
+		if result := runner.runFlow(context.Background(), flowFile, nil)[0]; result.Err != nil {
+			t.Fatalf("run flow: %v", result.Err)
+		}
+		if len(grants) != 0 || seen[0] != "Bearer tok-2" {
+			t.Fatalf("expected cached token to be reused, got grants %v headers %v", grants, seen)
+		}
+	})
+}
+
+func TestAuthHeaderStaticCredentials(t *testing.T) {
+	vars := map[string]string{"token": "abc", "user": "ann"}
+
+	runner := (&FlowRunner{}).withAuth(&AuthConfig{Type: "bearer", Token: "{{.token}}"})
+	name, value, err := runner.authHeader(context.Background(), vars)
+	if err != nil || name != "Authorization" || value != "Bearer abc" {
+		t.Fatalf("unexpected bearer header %q=%q (err %v)", name, value, err)
+	}
+
+	runner = runner.withAuth(&AuthConfig{Type: "basic", Username: "{{.user}}", Password: "pw", Header: "X-Auth"})
+	name, value, err = runner.authHeader(context.Background(), vars)
+	if err != nil || name != "X-Auth" || value != "Basic YW5uOnB3" {
+		t.Fatalf("unexpected basic header %q=%q (err %v)", name, value, err)
+	}
+
+	runner = runner.withAuth(&AuthConfig{Type: "digest"})
+	if _, _, err := runner.authHeader(context.Background(), vars); err == nil {
+		t.Fatalf("expected unsupported auth type error")
+	}
+}
+
+func TestSignSigV4MatchesAWSExample(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		cfg     SignConfig
+		at      time.Time
+		want    string
+	}{
+		{
+			// AWS documentation example (IAM ListUsers).
+			name:    "iam list users",
+			url:     "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			cfg: SignConfig{
+				AccessKey: "AKIDEXAMPLE",
+				SecretKey: "{{.aws_secret}}",
+				Region:    "us-east-1",
+				Service:   "iam",
+			},
+			at: time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC),
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date, " +
+				"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+		{
+			// AWS SDK for Go standalone signing vector: the escaped %2A in the
+			// path is escaped again in the canonical request.
+			name: "escaped path",
+			url:  "https://hostname-clusterkey.us-west-2.es.amazonaws.com/logs-%2A/_search?pretty=true",
+			cfg: SignConfig{
+				AccessKey:    "AKID",
+				SecretKey:    "SECRET",
+				SessionToken: "SESSION",
+				Region:       "us-west-2",
+				Service:      "es",
+			},
+			at: time.Unix(0, 0),
+			want: "AWS4-HMAC-SHA256 Credential=AKID/19700101/us-west-2/es/aws4_request, " +
+				"SignedHeaders=host;x-amz-date;x-amz-security-token, " +
+				"Signature=79d0760751907af16f64a537c1242416dacf51204a7dd5284492d15577973b91",
+		},
+	}
+
+	vars := map[string]string{"aws_secret": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatalf("build request: %v", err)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			cfg := tt.cfg
+			cfg.Type = "aws_sigv4"
+			if err := signRequest(req, &cfg, vars, tt.at); err != nil {
+				t.Fatalf("sign request: %v", err)
+			}
+
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Fatalf("unexpected authorization header:\n got %s\nwant %s", got, tt.want)
+			}
+			if want := tt.at.UTC().Format(sigV4TimeFormat); req.Header.Get("X-Amz-Date") != want {
+				t.Fatalf("expected x-amz-date %q, got %q", want, req.Header.Get("X-Amz-Date"))
+			}
+		})
+	}
+}
+
+func TestEscapePathSegments(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/", want: "/"},
+		{path: "/stage/items", want: "/stage/items"},
+		{path: "/example%20space/a%2Fb", want: "/example%2520space/a%252Fb"},
+		{path: "/users/a:b~c", want: "/users/a%3Ab~c"},
+	}
+
+	for _, tt := range tests {
+		if got := escapePathSegments(tt.path); got != tt.want {
+			t.Fatalf("escapePathSegments(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteHTTPStepSignsHMAC(t *testing.T) {
+	var captured *http.Request
+	var capturedBody string
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				captured = req
+				body, _ := io.ReadAll(req.Body)
+				capturedBody = string(body)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	step := Step{
+		Name:   "partner",
+		Method: http.MethodPost,
+		URL:    "http://partner.test/v1/orders?id={{.id}}",
+		Body:   `{"id":"{{.id}}"}`,
+		Sign:   &SignConfig{Type: "hmac", Secret: "{{.secret}}", Prefix: "sha256="},
+	}
+	vars := map[string]string{"id": "42", "secret": "k"}
+
+	if err := runner.executeStep(context.Background(), step, vars); err != nil {
+		t.Fatalf("execute step: %v", err)
+	}
+
+	timestamp := captured.Header.Get("X-Timestamp")
+	mac := hmac.New(sha256.New, []byte("k"))
+	mac.Write([]byte("POST\n/v1/orders?id=42\n" + timestamp + "\n" + capturedBody))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if timestamp == "" || captured.Header.Get("X-Signature") != want {
+		t.Fatalf("unexpected signature headers %v (want %s)", captured.Header, want)
+	}
+
+	step.Sign = &SignConfig{Type: "hmac", Secret: "k", Header: "X-Sig", Payload: "{{.method}} {{.path}}|{{.body}}", Encoding: "base64"}
+	if err := runner.executeStep(context.Background(), step, vars); err != nil {
+		t.Fatalf("execute step: %v", err)
+	}
+
+	mac = hmac.New(sha256.New, []byte("k"))
+	mac.Write([]byte(`POST /v1/orders|{"id":"42"}`))
+	if got := captured.Header.Get("X-Sig"); got != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("unexpected custom payload signature %q", got)
+	}
+}
+
+func TestRunFlowGraphQL(t *testing.T) {
+	dir := t.TempDir()
+	query := "query User($id: ID!, $limit: Int) { user(id: $id) { email } }\n"
+	if err := os.WriteFile(filepath.Join(dir, "user.graphql"), []byte(query), filePermission); err != nil {
+		t.Fatalf("write query file: %v", err)
+	}
+
+	flowYAML := `steps:
+  - name: user
+    url: http://api.test/graphql
+    graphql:
+      query_file: user.graphql
+      operation_name: User
+      variables:
+        id: "{{.user_id}}"
+        limit: 5
+    save:
+      email: user.email
+    expect:
+      user.email:
+        regex: "@"
+  - name: broken
+    url: http://api.test/graphql
+    graphql:
+      query: "{ broken }"
+`
+
+	flowFile := filepath.Join(dir, "graphql.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var requests []map[string]any
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				var payload map[string]any
+				if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+					return nil, err
+				}
+				payload["method"] = req.Method
+				payload["content_type"] = req.Header.Get("Content-Type")
+				requests = append(requests, payload)
+
+				body := `{"data":{"user":{"email":"ann@example.test"}}}`
+				if payload["query"] == "{ broken }" {
+					body = `{"data":null,"errors":[{"message":"Cannot query field \"broken\""}]}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(body)),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	result := runner.withKeepGoing(true).runFlow(context.Background(), flowFile, map[string]string{"user_id": "u-1"})[0]
+	if result.Err == nil || !strings.Contains(result.Err.Error(), `graphql errors: Cannot query field "broken"`) {
+		t.Fatalf("expected graphql errors to fail the flow, got %v", result.Err)
+	}
+	if result.Steps[0].Status != stepStatusSuccess || result.Steps[0].Type != "graphql" {
+		t.Fatalf("expected first step to pass, got %+v", result.Steps[0])
+	}
+
+	first := requests[0]
+	wantVars := map[string]any{"id": "u-1", "limit": float64(5)}
+	if first["query"] != strings.TrimSpace(query) || first["operationName"] != "User" || !reflect.DeepEqual(first["variables"], wantVars) {
+		t.Fatalf("unexpected graphql request %v", first)
+	}
+	if first["method"] != http.MethodPost || first["content_type"] != "application/json" {
+		t.Fatalf("expected JSON POST, got %v", first)
+	}
+}
+
+func TestGraphQLDataAllowErrors(t *testing.T) {
+	step := Step{Name: "partial", GraphQL: &GraphQLStep{AllowErrors: true}}
+	data, err := graphQLData(step, []byte(`{"data":{"a":1},"errors":[{"message":"partial"}]}`))
+	if err != nil || string(data) != `{"a":1}` {
+		t.Fatalf("expected data despite errors, got %s (err %v)", data, err)
+	}
+}
+
+func writeTestClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "go-flow-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), filePermission); err != nil {
+		t.Fatalf("write client cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), filePermission); err != nil {
+		t.Fatalf("write client key: %v", err)
+	}
+	return certPath, keyPath
+}
+
+func TestRunFlowHTTPClientOptions(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if req.URL.Path == "/old" {
+			http.Redirect(w, req, "/new", http.StatusFound)
+			return
+		}
+		fmt.Fprintf(w, `{"proto": %d}`, req.ProtoMajor)
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"proxied": %q}`, req.URL.String())
+	}))
+	defer proxy.Close()
+
+	dir := t.TempDir()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), caPEM, filePermission); err != nil {
+		t.Fatalf("write ca cert: %v", err)
+	}
+	writeTestClientCert(t, dir)
+
+	stepsYAML := `- name: h2
+  method: GET
+  url: "{{.base}}/h2"
+  http2: true
+  tls:
+    ca_cert: ca.pem
+    client_cert: client.pem
+    client_key: client-key.pem
+  save:
+    proto: proto
+- name: redirect
+  method: GET
+  url: "{{.base}}/old"
+  follow_redirects: false
+  http2: true
+  tls:
+    ca_cert: ca.pem
+    client_cert: client.pem
+    client_key: client-key.pem
+  expect_status: 302
+  save:
+    location: header:Location
+- name: proxied
+  method: GET
+  url: http://upstream.test/ping
+  proxy: "{{.proxy}}"
+  save:
+    proxied: proxied
+`
+	var steps []Step
+	if err := yaml.Unmarshal([]byte(stepsYAML), &steps); err != nil {
+		t.Fatalf("parse steps: %v", err)
+	}
+
+	runner := &FlowRunner{out: io.Discard, client: &http.Client{}, transports: newTransportCache()}
+	vars := map[string]string{"base": server.URL, "proxy": proxy.URL}
+	for _, step := range withStepDir(steps, dir) {
+		if err := runner.executeStep(context.Background(), step, vars); err != nil {
+			t.Fatalf("step %q: %v", step.Name, err)
+		}
+	}
+
+	want := map[string]string{
+		"proto":    "2",
+		"location": "/new",
+		"proxied":  "http://upstream.test/ping",
+	}
+	for name, value := range want {
+		if vars[name] != value {
+			t.Fatalf("expected %s=%q, got %q", name, value, vars[name])
+		}
+	}
+
+	if got := len(runner.transports.transports); got != 2 {
+		t.Fatalf("expected 2 cached transports (TLS host and proxy), got %d", got)
+	}
+}
+
+func TestCloseClosesCachedTransports(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	runner := &FlowRunner{out: io.Discard, client: &http.Client{}, transports: newTransportCache()}
+	step := Step{Name: "ping", Method: http.MethodGet, URL: server.URL, TLS: &HTTPTLSConfig{SkipVerify: true}}
+	if err := runner.executeStep(context.Background(), step, map[string]string{}); err != nil {
+		t.Fatalf("step: %v", err)
+	}
+
+	if got := len(runner.transports.transports); got != 1 {
+		t.Fatalf("expected 1 cached transport, got %d", got)
+	}
+
+	if err := runner.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if got := len(runner.transports.transports); got != 0 {
+		t.Fatalf("expected transports to be dropped on close, got %d", got)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the idle keep-alive connection to be closed")
+	}
+}
+
+func TestExecuteHTTPStepStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		flusher := w.(http.Flusher)
+		switch req.URL.Path {
+		case "/events":
+			if req.Header.Get("Accept") != "text/event-stream" {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, ": keep-alive\n\nevent: progress\nid: 1\ndata: {\"pct\": 50}\n\n")
+			fmt.Fprint(w, "event: progress\ndata: {\"pct\": 100,\ndata: \"done\": true}\n\n")
+			fmt.Fprint(w, "data: never read\n\n")
+		case "/lines":
+			fmt.Fprint(w, "{\"token\":\"Hel\"}\n\n{\"token\":\"lo\"}\nplain text\n")
+		}
+		flusher.Flush()
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	runner := &FlowRunner{out: io.Discard, client: server.Client()}
+	vars := map[string]string{"base": server.URL}
+	done, hundred, bang := "true", "100", "!"
+
+	sse := Step{
+		Name:   "sse",
+		Method: http.MethodGet,
+		URL:    "{{.base}}/events",
+		Stream: &StreamConfig{
+			Until:    map[string]Matcher{"data.done": {Equals: &done}},
+			Duration: "2s",
+		},
+		Save:   map[string]string{"first_id": "0.id", "count": "#"},
+		Expect: map[string]Matcher{"1.data.pct": {Equals: &hundred}},
+	}
+	if err := runner.executeStep(context.Background(), sse, vars); err != nil {
+		t.Fatalf("sse step: %v", err)
+	}
+	if vars["first_id"] != "1" || vars["count"] != "2" {
+		t.Fatalf("unexpected saved vars %v", vars)
+	}
+
+	lines := Step{
+		Name:   "lines",
+		Method: http.MethodGet,
+		URL:    "{{.base}}/lines",
+		Stream: &StreamConfig{Format: "lines", Duration: "200ms"},
+		Save:   map[string]string{"events": "#.data"},
+	}
+	if err := runner.executeStep(context.Background(), lines, vars); err != nil {
+		t.Fatalf("lines step: %v", err)
+	}
+	if want := `[{"token":"Hel"},{"token":"lo"},"plain text"]`; vars["events"] != want {
+		t.Fatalf("expected events %s, got %s", want, vars["events"])
+	}
+
+	limited := lines
+	limited.Stream = &StreamConfig{Format: "lines", MaxEvents: 1}
+	limited.Save = map[string]string{"count": "#"}
+	if err := runner.executeStep(context.Background(), limited, vars); err != nil || vars["count"] != "1" {
+		t.Fatalf("expected max_events to stop after 1 event, got count %q (err %v)", vars["count"], err)
+	}
+
+	unmatched := lines
+	unmatched.Stream = &StreamConfig{Format: "lines", Duration: "100ms", Until: map[string]Matcher{"data.token": {Equals: &bang}}}
+	unmatched.Save = nil
+	if err := runner.executeStep(context.Background(), unmatched, vars); err == nil || !strings.Contains(err.Error(), "duration elapsed") {
+		t.Fatalf("expected until to fail after the duration, got %v", err)
+	}
+}
+
+func TestExecuteWebSocketStep(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Doc") != "doc-1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch gjson.GetBytes(data, "type").String() {
+			case "join":
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"presence","users":2}`))
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"joined","session":{"id":"s-42"}}`))
+			default:
+				_ = conn.WriteMessage(websocket.TextMessage, data)
+			}
+		}
+	}))
+	defer server.Close()
+
+	stepYAML := `name: collab
+websocket:
+  url: "{{.ws_base}}/docs"
+  headers:
+    X-Doc: doc-1
+  script:
+    - send: '{"type": "join"}'
+      receive:
+        type: joined
+      save:
+        session_id: session.id
+    - send: '{"type": "edit", "session": "{{.session_id}}"}'
+      receive:
+        type: edit
+        session: "{{.session_id}}"
+save:
+  message_count: "#"
+`
+	var step Step
+	if err := yaml.Unmarshal([]byte(stepYAML), &step); err != nil {
+		t.Fatalf("parse step: %v", err)
+	}
+
+	runner := &FlowRunner{out: io.Discard}
+	vars := map[string]string{"ws_base": "ws" + strings.TrimPrefix(server.URL, "http")}
+	if err := runner.executeStep(context.Background(), step, vars); err != nil {
+		t.Fatalf("websocket step: %v", err)
+	}
+	if vars["session_id"] != "s-42" || vars["message_count"] != "3" {
+		t.Fatalf("unexpected saved vars %v", vars)
+	}
+
+	step.WebSocket.Script = []WebSocketAction{{
+		Send:    `{"type": "join"}`,
+		Receive: map[string]Matcher{"type": {Equals: &step.Name}},
+		Timeout: "100ms",
+	}}
+	if err := runner.executeStep(context.Background(), step, vars); err == nil || !strings.Contains(err.Error(), "script[0]") {
+		t.Fatalf("expected unmatched receive to fail, got %v", err)
+	}
+}
+
+func TestExecuteHTTPStepSaveBodyTo(t *testing.T) {
+	content := []byte("%PDF-1.7\x00\x01binary invoice")
+	sum := sha256.Sum256(content)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "golden.pdf"), content, filePermission); err != nil {
+		t.Fatalf("write golden: %v", err)
+	}
+	changed := append([]byte(nil), content...)
+	changed[10] = 'X'
+	if err := os.WriteFile(filepath.Join(dir, "stale.pdf"), changed, filePermission); err != nil {
+		t.Fatalf("write stale golden: %v", err)
+	}
+
+	runner := &FlowRunner{
+		out: io.Discard,
+		client: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(content)),
+					Header:     http.Header{"Content-Type": {"application/pdf"}},
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	size := strconv.Itoa(len(content))
+	digest := hex.EncodeToString(sum[:])
+	step := Step{
+		Name:       "invoice",
+		Method:     http.MethodGet,
+		URL:        "http://api.test/invoices/1.pdf",
+		SaveBodyTo: "out/{{.invoice_id}}.pdf",
+		ExpectFile: map[string]Matcher{
+			"size":   {Equals: &size},
+			"sha256": {Equals: &digest},
+		},
+		Golden: "golden.pdf",
+		Save:   map[string]string{"content_type": "header:Content-Type"},
+		dir:    dir,
+	}
+
+	vars := map[string]string{"invoice_id": "1"}
+	if err := runner.executeStep(context.Background(), step, vars); err != nil {
+		t.Fatalf("save_body_to step: %v", err)
+	}
+
+	wantPath := filepath.Join(dir, "out", "1.pdf")
+	if vars["last_file"] != wantPath || vars["content_type"] != "application/pdf" {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+	if saved, err := os.ReadFile(wantPath); err != nil || !bytes.Equal(saved, content) {
+		t.Fatalf("expected saved file to match response, got %q (err %v)", saved, err)
+	}
+
+	step.Golden = "stale.pdf"
+	if err := runner.executeStep(context.Background(), step, vars); err == nil || !strings.Contains(err.Error(), "at byte 10") {
+		t.Fatalf("expected golden mismatch at byte 10, got %v", err)
+	}
+
+	step.Golden = ""
+	step.Save = map[string]string{"id": "data.id"}
+	if err := runner.executeStep(context.Background(), step, vars); err == nil || !strings.Contains(err.Error(), "save_body_to cannot be combined") {
+		t.Fatalf("expected body saves to be rejected, got %v", err)
+	}
+}
+
+func TestResolveSQLDriver(t *testing.T) {
+	tests := []struct {
+		name       string
+		driver     string
+		url        string
+		wantDriver string
+		wantDSN    string
+	}{
+		{name: "default postgres", url: "postgres://u:p@db:5432/app?sslmode=disable", wantDriver: "postgres", wantDSN: "postgres://u:p@db:5432/app?sslmode=disable"},
+		{name: "mysql url", url: "mysql://root:secret@db:3306/shop?parseTime=true", wantDriver: "mysql", wantDSN: "root:secret@tcp(db:3306)/shop?clientFoundRows=true&parseTime=true"},
+		{name: "mysql native dsn", driver: "mysql", url: "root@tcp(db)/shop", wantDriver: "mysql", wantDSN: "root@tcp(db)/shop"},
+		{name: "sqlite url", url: "sqlite:///tmp/flow.db", wantDriver: "sqlite", wantDSN: "/tmp/flow.db"},
+		{name: "sqlite memory", driver: "sqlite", url: ":memory:", wantDriver: "sqlite", wantDSN: ":memory:"},
+		{name: "mssql scheme", url: "mssql://sa:pw@db:1433?database=app", wantDriver: "sqlserver", wantDSN: "sqlserver://sa:pw@db:1433?database=app"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dialect, dsn, err := resolveSQLDriver(tc.driver, tc.url)
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			if dialect.driver != tc.wantDriver || dsn != tc.wantDSN {
+				t.Fatalf("expected %s %q, got %s %q", tc.wantDriver, tc.wantDSN, dialect.driver, dsn)
+			}
+		})
+	}
+
+	if _, _, err := resolveSQLDriver("oracle", "oracle://db"); err == nil {
+		t.Fatalf("expected unsupported driver error")
+	}
+}
+
+func TestRunFlowSQLite(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  database_url: sqlite://` + filepath.ToSlash(filepath.Join(dir, "flow.db")) + `
+steps:
+  - name: create
+    sql: CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, active INTEGER)
+  - name: insert
+    sql: INSERT INTO users (email, active) VALUES ('a@example.test', 1), ('b@example.test', 0)
+    expect_affected_rows: 2
+  - name: count-active
+    sql: SELECT id FROM users WHERE active = 1
+    expect_affected_rows: 1
+  - name: deactivate
+    sql: UPDATE users SET active = 0
+    expect_affected_rows: 2
+  - name: first
+    sql: SELECT id, email FROM users ORDER BY id
+    save:
+      first_email: email
+  - name: confirm
+    sql: "SELECT id FROM users WHERE email = '{{.first_email}}'"
+    expect_affected_rows: 1
+`
+	flowFile := filepath.Join(dir, "sqlite.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	runner := &FlowRunner{out: io.Discard}
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err != nil {
+		t.Fatalf("run flow: %v", result.Err)
+	}
+	if len(result.Steps) != 6 {
+		t.Fatalf("expected 6 steps, got %d", len(result.Steps))
+	}
+}
+
+func TestRunFlowSQLParams(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  database_url: sqlite://` + filepath.ToSlash(filepath.Join(dir, "params.db")) + `
+  last_name: O'Brien
+steps:
+  - name: create
+    sql: CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, age INTEGER, nickname TEXT)
+  - name: insert
+    sql: INSERT INTO people (name, age, nickname) VALUES (?, ?, ?)
+    params:
+      - "Conan {{.last_name}}"
+      - 42
+      - null
+    expect_affected_rows: 1
+  - name: lookup
+    sql: SELECT name, age FROM people WHERE name = ? AND age > ? AND nickname IS NULL
+    params: ["Conan {{.last_name}}", 40]
+    save:
+      saved_name: name
+  - name: lookup-numbered
+    sql: SELECT name FROM people WHERE age > $2 AND name = $1 AND name <> '$2'
+    params: ["Conan {{.last_name}}", 40]
+    expect_rows: 1
+`
+	flowFile := filepath.Join(dir, "params.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var out strings.Builder
+	runner := &FlowRunner{out: &out}
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err != nil {
+		t.Fatalf("run flow: %v", result.Err)
+	}
+	if !strings.Contains(out.String(), "saved_name = Conan O'Brien") {
+		t.Fatalf("expected name with apostrophe to round-trip, got output:\n%s", out.String())
+	}
+}
+
+func TestSQLDialectBindSQL(t *testing.T) {
+	args := []any{"a", 2}
+	tests := []struct {
+		name     string
+		dialect  string
+		stmt     string
+		wantStmt string
+		wantArgs []any
+		wantErr  string
+	}{
+		{name: "postgres unchanged", dialect: "postgres", stmt: "SELECT $2, $1", wantStmt: "SELECT $2, $1", wantArgs: args},
+		{name: "mysql reorders", dialect: "mysql", stmt: "SELECT $2, $1, $2", wantStmt: "SELECT ?, ?, ?", wantArgs: []any{2, "a", 2}},
+		{name: "mysql native", dialect: "mysql", stmt: "SELECT ?, ?", wantStmt: "SELECT ?, ?", wantArgs: args},
+		{name: "mysql escaped quote", dialect: "mysql", stmt: `SELECT 'it\'s $1', $1`, wantStmt: `SELECT 'it\'s $1', ?`, wantArgs: []any{"a"}},
+		{name: "sqlite native", dialect: "sqlite", stmt: "SELECT $2, $1", wantStmt: "SELECT $2, $1", wantArgs: args},
+		{name: "sqlserver", dialect: "sqlserver", stmt: "SELECT $1 -- $2\n, $2", wantStmt: "SELECT @p1 -- $2\n, @p2", wantArgs: args},
+		{name: "quotes and comments", dialect: "sqlserver", stmt: `SELECT '$1''$2', "a$1", /* $1 */ $1`, wantStmt: `SELECT '$1''$2', "a$1", /* $1 */ @p1`, wantArgs: args},
+		{name: "identifiers and money", dialect: "mysql", stmt: "SELECT col$1, '$', $ FROM t", wantStmt: "SELECT col$1, '$', $ FROM t", wantArgs: args},
+		{name: "missing param", dialect: "mysql", stmt: "SELECT $3", wantErr: "placeholder $3 has no matching params entry (2 given)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, bound, err := sqlDialects[tt.dialect].bindSQL(tt.stmt, args)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("bind: %v", err)
+			}
+			if stmt != tt.wantStmt || !reflect.DeepEqual(bound, tt.wantArgs) {
+				t.Fatalf("expected %q %v, got %q %v", tt.wantStmt, tt.wantArgs, stmt, bound)
+			}
+		})
+	}
+}
+
+func TestConnCacheReusesSQLPool(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  database_url: "sqlite::memory:"
+steps:
+  - name: create
+    sql: CREATE TABLE events (id INTEGER PRIMARY KEY)
+  - name: insert
+    sql: INSERT INTO events (id) VALUES (1), (2)
+  - name: count
+    sql: SELECT id FROM events
+    expect_affected_rows: 2
+`
+	flowFile := filepath.Join(dir, "memory.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	runner := &FlowRunner{out: io.Discard, conns: newConnCache()}
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err != nil {
+		t.Fatalf("expected the in-memory database to persist across steps: %v", result.Err)
+	}
+
+	if got := len(runner.conns.sqlDBs.entries); got != 1 {
+		t.Fatalf("expected 1 pooled database, got %d", got)
+	}
+	db := runner.conns.sqlDBs.entries["sqlite|:memory:"].conn
+
+	if err := runner.Close(); err != nil {
+		t.Fatalf("close runner: %v", err)
+	}
+	if err := db.Ping(); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("expected Close to close the pool, got %v", err)
+	}
+}
+
+func TestRunFlowSQLWriteKeepsAffectedRows(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  database_url: sqlite://` + filepath.ToSlash(filepath.Join(dir, "stock.db")) + `
+steps:
+  - name: create
+    sql: CREATE TABLE stock (sku TEXT PRIMARY KEY, qty INTEGER)
+  - name: insert
+    sql: INSERT INTO stock (sku, qty) VALUES ('A-1', 1), ('A-2', 2), ('B-1', 3)
+  - name: restock
+    sql: UPDATE stock SET qty = qty + 10 WHERE sku LIKE 'A-%'
+    expect_affected_rows: 2
+    expect_rows: 0
+    expect:
+      "#": 0
+  - name: restock-returning
+    sql: UPDATE stock SET qty = qty + 1 WHERE sku LIKE 'A-%' RETURNING sku, qty
+    expect_affected_rows: 2
+    expect:
+      "#.qty": "[12,13]"
+`
+	flowFile := filepath.Join(dir, "stock.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var out strings.Builder
+	runner := &FlowRunner{out: &out}
+	if result := runner.runFlow(context.Background(), flowFile, nil)[0]; result.Err != nil {
+		t.Fatalf("run flow: %v\n%s", result.Err, out.String())
+	}
+}
+
+func TestRunFlowSQLRetryUntilBody(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  database_url: sqlite://` + filepath.ToSlash(filepath.Join(dir, "jobs.db")) + `
+steps:
+  - name: create
+    sql: CREATE TABLE jobs (id INTEGER PRIMARY KEY, polls INTEGER, state TEXT)
+  - name: insert
+    sql: INSERT INTO jobs (polls, state) VALUES (0, 'pending')
+  - name: poll
+    sql: UPDATE jobs SET polls = polls + 1, state = CASE WHEN polls >= 2 THEN 'done' ELSE 'pending' END RETURNING polls, state
+    retry:
+      attempts: 5
+      interval: 1ms
+      until:
+        body:
+          0.state: done
+  - name: check
+    sql: SELECT polls FROM jobs
+    expect:
+      0.polls: 3
+`
+	flowFile := filepath.Join(dir, "poll.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var out strings.Builder
+	runner := &FlowRunner{out: &out}
+	if result := runner.runFlow(context.Background(), flowFile, nil)[0]; result.Err != nil {
+		t.Fatalf("run flow: %v\n%s", result.Err, out.String())
+	}
+	if got := strings.Count(out.String(), "Retrying step"); got != 2 {
+		t.Fatalf("expected 2 retries, got %d:\n%s", got, out.String())
+	}
+}
+
+func TestRunFlowSQLRetryUntilRowsOnWrite(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  database_url: sqlite://` + filepath.ToSlash(filepath.Join(dir, "drain.db")) + `
+steps:
+  - name: create
+    sql: CREATE TABLE queue (id INTEGER PRIMARY KEY, done INTEGER)
+  - name: insert
+    sql: INSERT INTO queue (done) VALUES (0), (0), (0)
+  - name: drain
+    sql: UPDATE queue SET done = 1 WHERE id = (SELECT MIN(id) FROM queue WHERE done = 0)
+    retry:
+      attempts: 5
+      interval: 1ms
+      until:
+        rows: 0
+        body:
+          "#": 0
+`
+	flowFile := filepath.Join(dir, "drain.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var out strings.Builder
+	runner := &FlowRunner{out: &out}
+	if result := runner.runFlow(context.Background(), flowFile, nil)[0]; result.Err != nil {
+		t.Fatalf("run flow: %v\n%s", result.Err, out.String())
+	}
+	if got := strings.Count(out.String(), "Retrying step"); got != 3 {
+		t.Fatalf("expected 3 retries while the update changed rows, got %d:\n%s", got, out.String())
+	}
+}
+
+func TestRunFlowSQLSaveRows(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  database_url: sqlite://` + filepath.ToSlash(filepath.Join(dir, "orders.db")) + `
+steps:
+  - name: create
+    sql: CREATE TABLE line_items (id INTEGER PRIMARY KEY, order_id INTEGER, sku TEXT, qty INTEGER, note TEXT)
+  - name: insert
+    sql: INSERT INTO line_items (order_id, sku, qty, note) VALUES (7, 'A-1', 2, NULL), (7, 'B-2', 1, 'gift'), (7, 'C-3', 5, NULL)
+  - name: items
+    sql: SELECT sku, qty, note FROM line_items WHERE order_id = ? ORDER BY id
+    params: [7]
+    save_rows: items
+    expect_rows: 3
+    expect:
+      "#.sku": '["A-1","B-2","C-3"]'
+      1.note: gift
+  - name: none
+    sql: SELECT sku FROM line_items WHERE order_id = 8
+    save_rows: missing
+    expect_rows: 0
+  - name: use-saved
+    sql: SELECT 1 WHERE ? = 'B-2'
+    params: ["{{jsonPath .items \"1.sku\"}}"]
+    expect_rows: 1
+`
+	flowFile := filepath.Join(dir, "rows.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var out strings.Builder
+	runner := &FlowRunner{out: &out}
+	result := runner.runFlow(context.Background(), flowFile, nil)[0]
+	if result.Err != nil {
+		t.Fatalf("run flow: %v\n%s", result.Err, out.String())
+	}
+	if !strings.Contains(out.String(), " missing = []") {
+		t.Fatalf("expected zero rows to save an empty array, got output:\n%s", out.String())
+	}
+
+	step := Step{Name: "count", SQL: "SELECT sku FROM line_items", ExpectRows: new(int)}
+	vars := map[string]string{"database_url": "sqlite://" + filepath.Join(dir, "orders.db")}
+	if err := runner.executeStep(context.Background(), step, vars); err == nil || !strings.Contains(err.Error(), "expected 0 rows, got 3") {
+		t.Fatalf("expected expect_rows mismatch, got %v", err)
+	}
+}
+
+func TestRunFlowSQLExpectResult(t *testing.T) {
+	dir := t.TempDir()
+	flowYAML := `vars:
+  database_url: sqlite://` + filepath.ToSlash(filepath.Join(dir, "shop.db")) + `
+steps:
+  - name: create
+    sql: CREATE TABLE products (id INTEGER PRIMARY KEY, sku TEXT, price REAL, note TEXT, created_at TEXT)
+  - name: insert
+    sql: INSERT INTO products (sku, price, note, created_at) VALUES ('A-1', 9.990000001, NULL, '2026-01-02'), ('B-2', 20, 'gift', '2026-03-04')
+  - name: unordered
+    sql: SELECT * FROM products
+    expect_result:
+      rows:
+        - {sku: B-2, note: gift, created_at: {not_null: true}}
+        - {sku: {regex: "^A-"}, price: {approx: 9.99}, note: null}
+  - name: ordered
+    sql: SELECT sku, price FROM products ORDER BY id
+    expect_result:
+      ordered: true
+      rows:
+        - {sku: A-1}
+        - {sku: B-2, price: {approx: 19.5, tolerance: 0.5}}
+  - name: loose-row-first
+    sql: SELECT sku FROM products ORDER BY id
+    expect_result:
+      - sku: {not_null: true}
+      - sku: A-1
+  - name: shorthand
+    sql: SELECT sku FROM products WHERE note IS NULL
+    expect_result:
+      - sku: A-1
+  - name: case-insensitive
+    sql: SELECT id, sku FROM products WHERE note IS NULL
+    save:
+      product_id: ID
+    expect_result:
+      - {ID: 1, Sku: A-1}
+`
+	flowFile := filepath.Join(dir, "result.yaml")
+	if err := os.WriteFile(flowFile, []byte(flowYAML), filePermission); err != nil {
+		t.Fatalf("write flow file: %v", err)
+	}
+
+	var out strings.Builder
+	runner := &FlowRunner{out: &out}
+	if result := runner.runFlow(context.Background(), flowFile, nil)[0]; result.Err != nil {
+		t.Fatalf("run flow: %v\n%s", result.Err, out.String())
+	}
+
+	vars := map[string]string{"database_url": "sqlite://" + filepath.Join(dir, "shop.db")}
+	tests := []struct {
+		name  string
+		step  string
+		wants []string
+	}{
+		{
+			name: "unordered cell mismatch",
+			step: `
+name: prices
+sql: SELECT sku, price FROM products
+expect_result:
+  - {sku: A-1, price: 9.99}
+  - {sku: B-2, price: 25}
+`,
+			wants: []string{`rows[1].price: expected "25", got 20 (closest: result[1])`},
+		},
+		{
+			name: "ordered missing and unexpected rows",
+			step: `
+name: order
+sql: SELECT sku FROM products ORDER BY sku DESC
+expect_result:
+  ordered: true
+  rows:
+    - {sku: A-1}
+`,
+			wants: []string{"expected 1 rows, got 2", `rows[0].sku: expected "A-1", got "B-2"`, `result[1]: unexpected row {"sku":"A-1"}`},
+		},
+		{
+			name: "missing row",
+			step: `
+name: missing
+sql: SELECT sku FROM products WHERE sku = 'A-1'
+expect_result:
+  - {sku: A-1}
+  - {sku: {not_null: true}}
+`,
+			wants: []string{"expected 2 rows, got 1", "rows[1]: missing from result"},
+		},
+		{
+			name: "unknown column",
+			step: `
+name: columns
+sql: SELECT sku FROM products
+expect_result:
+  - {sku: A-1, qty: 1}
+`,
+			wants: []string{"columns qty not in result (columns: sku)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var step Step
+			if err := yaml.Unmarshal([]byte(tt.step), &step); err != nil {
+				t.Fatalf("parse step: %v", err)
+			}
+			step.TimeoutSeconds = defaultStepTimeoutSeconds
+
+			logCtx := &stepLogContext{}
+			err := runner.executeSQLStep(context.Background(), step, step.SQL, vars, logCtx, &stepOutcome{})
+			if err == nil {
+				t.Fatalf("expected result mismatch")
+			}
+
+			diff, _ := logCtx.Response["result_diff"].([]string)
+			for _, want := range tt.wants {
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("expected error to contain %q, got %v", want, err)
+				}
+				if !slices.Contains(diff, want) {
+					t.Fatalf("expected logged diff to contain %q, got %v", want, diff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matrix lists input rows for data-driven flows and steps. Rows can be inline
+// or loaded from a CSV (header row required) or JSON (array of objects) file.
+// In YAML it accepts a list of rows, a file path, or a mapping with both.
+type Matrix struct {
+	Rows []map[string]string `yaml:"rows"`
+	File string              `yaml:"file"`
+}
+
+func (m *Matrix) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return node.Decode(&m.Rows)
+	case yaml.ScalarNode:
+		m.File = node.Value
+		return nil
+	}
+
+	type rawMatrix Matrix
+	var raw rawMatrix
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	*m = Matrix(raw)
+	return nil
+}
+
+// matrixRow is a single set of vars plus a label identifying the iteration.
+type matrixRow struct {
+	Label string
+	Vars  map[string]string
+}
+
+func (m *Matrix) load(dir string, vars map[string]string) ([]matrixRow, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	rows := make([]map[string]string, 0, len(m.Rows))
+	rows = append(rows, m.Rows...)
+
+	if file := strings.TrimSpace(render(m.File, vars)); file != "" {
+		fileRows, err := readMatrixFile(resolveRelativePath(dir, file))
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, fileRows...)
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("matrix has no rows")
+	}
+
+	out := make([]matrixRow, 0, len(rows))
+	for idx, row := range rows {
+		out = append(out, matrixRow{
+			Label: matrixRowLabel(idx, row),
+			Vars:  row,
+		})
+	}
+
+	return out, nil
+}
+
+func readMatrixFile(path string) ([]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read matrix file: %w", err)
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err := parseMatrixCSV(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse matrix file %q: %w", path, err)
+		}
+		return rows, nil
+	case ".json":
+		rows, err := parseMatrixJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse matrix file %q: %w", path, err)
+		}
+		return rows, nil
+	default:
+		return nil, fmt.Errorf("matrix file %q must be .csv or .json", path)
+	}
+}
+
+func parseMatrixCSV(data []byte) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range header {
+		header[idx] = strings.TrimSpace(header[idx])
+	}
+
+	var rows []map[string]string
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]string, len(header))
+		for idx, column := range header {
+			if column == "" {
+				continue
+			}
+			row[column] = record[idx]
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func parseMatrixJSON(data []byte) ([]map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var items []map[string]any
+	if err := decoder.Decode(&items); err != nil {
+		return nil, err
+	}
+
+	rows := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		row := make(map[string]string, len(item))
+		for key, value := range item {
+			switch v := value.(type) {
+			case nil:
+				row[key] = ""
+			case string:
+				row[key] = v
+			case json.Number, bool:
+				row[key] = fmt.Sprint(v)
+			default:
+				encoded, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				row[key] = string(encoded)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// matrixRowLabel names an iteration by its columns (sorted for stability) so
+// reports can tell iterations apart; empty rows fall back to their index.
+func matrixRowLabel(idx int, row map[string]string) string {
+	if len(row) == 0 {
+		return fmt.Sprintf("#%d", idx+1)
+	}
+
+	keys := make([]string, 0, len(row))
+	for key := range row {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+row[key])
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func matrixName(name, label string) string {
+	return fmt.Sprintf("%s[%s]", name, label)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fullstorydev/grpcurl"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc"
+)
+
+const mongoDisconnectTimeout = 2 * time.Second
+
+// connPool holds one long-lived connection per key. Each key is opened at
+// most once at a time, so parallel flows share a connection without
+// serialising unrelated connects behind one lock.
+type connPool[T any] struct {
+	mu      sync.Mutex
+	entries map[string]*pooledConn[T]
+}
+
+type pooledConn[T any] struct {
+	mu     sync.Mutex
+	conn   T
+	opened bool
+}
+
+func (p *connPool[T]) get(key string, open func() (T, error)) (T, error) {
+	p.mu.Lock()
+	if p.entries == nil {
+		p.entries = make(map[string]*pooledConn[T])
+	}
+	entry, ok := p.entries[key]
+	if !ok {
+		entry = &pooledConn[T]{}
+		p.entries[key] = entry
+	}
+	p.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.opened {
+		return entry.conn, nil
+	}
+
+	conn, err := open()
+	if err != nil {
+		return conn, err
+	}
+
+	entry.conn, entry.opened = conn, true
+	return conn, nil
+}
+
+func (p *connPool[T]) closeAll(closeConn func(T) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for key, entry := range p.entries {
+		if entry.opened {
+			if err := closeConn(entry.conn); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		delete(p.entries, key)
+	}
+
+	return errors.Join(errs...)
+}
+
+// grpcDescriptor is a cached descriptor source plus the reflection client
+// that backs it, reset when the run ends.
+type grpcDescriptor struct {
+	source  grpcurl.DescriptorSource
+	cleanup func()
+}
+
+// connCache keeps SQL pools, Mongo clients, gRPC connections, and gRPC
+// descriptor sources open for the whole run. FlowRunner.Close closes them.
+type connCache struct {
+	sqlDBs      connPool[*sql.DB]
+	mongo       connPool[*mongo.Client]
+	grpc        connPool[*grpc.ClientConn]
+	descriptors connPool[grpcDescriptor]
+}
+
+func newConnCache() *connCache {
+	return &connCache{}
+}
+
+func (c *connCache) close() error {
+	descErr := c.descriptors.closeAll(func(desc grpcDescriptor) error {
+		if desc.cleanup != nil {
+			desc.cleanup()
+		}
+		return nil
+	})
+
+	return errors.Join(
+		descErr,
+		c.grpc.closeAll(func(conn *grpc.ClientConn) error { return conn.Close() }),
+		c.sqlDBs.closeAll(func(db *sql.DB) error { return db.Close() }),
+		c.mongo.closeAll(disconnectMongo),
+	)
+}
+
+func disconnectMongo(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoDisconnectTimeout)
+	defer cancel()
+	return client.Disconnect(ctx)
+}
+
+// sqlDB returns a pool for the DSN, opened and pinged on first use. The
+// release func closes the pool when the runner has no connection cache.
+func (r *FlowRunner) sqlDB(ctx context.Context, dialect sqlDialect, dsn string) (*sql.DB, func(), error) {
+	open := func() (*sql.DB, error) {
+		db, err := sql.Open(dialect.driver, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("open database: %w", err)
+		}
+
+		if dialect.singleConn {
+			db.SetMaxOpenConns(1)
+		}
+
+		if err := db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("ping database: %w", err)
+		}
+		return db, nil
+	}
+
+	if r.conns == nil {
+		db, err := open()
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, func() { db.Close() }, nil
+	}
+
+	db, err := r.conns.sqlDBs.get(dialect.driver+"|"+dsn, open)
+	return db, func() {}, err
+}
+
+// mongoClient returns a connected client for the URI, pinged on first use.
+func (r *FlowRunner) mongoClient(ctx context.Context, uri string) (*mongo.Client, func(), error) {
+	open := func() (*mongo.Client, error) {
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			return nil, fmt.Errorf("connect mongo: %w", err)
+		}
+
+		if err := client.Ping(ctx, nil); err != nil {
+			_ = disconnectMongo(client)
+			return nil, fmt.Errorf("ping mongo: %w", err)
+		}
+		return client, nil
+	}
+
+	if r.conns == nil {
+		client, err := open()
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, func() { _ = disconnectMongo(client) }, nil
+	}
+
+	client, err := r.conns.mongo.get(uri, open)
+	return client, func() {}, err
+}
+
+// grpcConnKey identifies a gRPC connection by target and TLS settings.
+func grpcConnKey(target string, cfg *GRPCStep, vars map[string]string) string {
+	return strings.Join([]string{
+		target,
+		strconv.FormatBool(cfg.UseTLS),
+		strconv.FormatBool(cfg.SkipTLSVerify),
+		render(cfg.CACert, vars),
+		render(cfg.ClientCert, vars),
+		render(cfg.ClientKey, vars),
+		render(cfg.ServerName, vars),
+	}, "|")
+}
+
+// grpcConn returns a connection for the step's target and TLS settings.
+func (r *FlowRunner) grpcConn(ctx context.Context, target string, cfg *GRPCStep, vars map[string]string) (*grpc.ClientConn, string, func(), error) {
+	key := grpcConnKey(target, cfg, vars)
+	open := func() (*grpc.ClientConn, error) {
+		return dialGRPC(ctx, target, cfg, vars)
+	}
+
+	if r.conns == nil {
+		conn, err := open()
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return conn, key, func() { conn.Close() }, nil
+	}
+
+	conn, err := r.conns.grpc.get(key, open)
+	return conn, key, func() {}, err
+}
+
+// grpcDescriptorSource returns the descriptor source for a connection. When
+// cached, reflection runs on a context that outlives the step, so symbols
+// resolved by one step are reused by the next.
+func (r *FlowRunner) grpcDescriptorSource(ctx context.Context, conn *grpc.ClientConn, connKey string, cfg *GRPCStep, vars map[string]string, reflectionHeaders []string) (grpcurl.DescriptorSource, func(), error) {
+	if r.conns == nil {
+		return buildDescriptorSource(ctx, conn, cfg, vars, reflectionHeaders)
+	}
+
+	key := strings.Join([]string{
+		connKey,
+		strconv.FormatBool(boolValue(cfg.UseReflection, true)),
+		strings.Join(reflectionHeaders, "\n"),
+		strings.Join(renderStringSlice(cfg.ProtoSets, vars), ","),
+		strings.Join(renderStringSlice(cfg.ProtoFiles, vars), ","),
+		strings.Join(renderStringSlice(cfg.ProtoPaths, vars), ","),
+	}, "|")
+
+	desc, err := r.conns.descriptors.get(key, func() (grpcDescriptor, error) {
+		source, cleanup, err := buildDescriptorSource(context.Background(), conn, cfg, vars, reflectionHeaders)
+		return grpcDescriptor{source: source, cleanup: cleanup}, err
+	})
+	return desc.source, nil, err
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	reportFormatJUnit = "junit"
+	reportFormatJSON  = "json"
+)
+
+type reportSpec struct {
+	Format string
+	Path   string
+}
+
+func parseReportSpecs(values []string) ([]reportSpec, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	const specParts = 2
+
+	specs := make([]reportSpec, 0, len(values))
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(value, "=", specParts)
+		if len(parts) != specParts || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid report %q, expected format=path", value)
+		}
+
+		format := strings.ToLower(strings.TrimSpace(parts[0]))
+		switch format {
+		case reportFormatJUnit, reportFormatJSON:
+		default:
+			return nil, fmt.Errorf("unsupported report format %q (use junit or json)", parts[0])
+		}
+
+		specs = append(specs, reportSpec{Format: format, Path: strings.TrimSpace(parts[1])})
+	}
+
+	return specs, nil
+}
+
+func writeReports(specs []reportSpec, results []flowResult) error {
+	for _, spec := range specs {
+		if err := writeReportFile(spec, results); err != nil {
+			return err
+		}
+		fmt.Printf("%s%s report saved to %s%s\n", colorCyan, spec.Format, spec.Path, colorReset)
+	}
+
+	return nil
+}
+
+func writeReportFile(spec reportSpec, results []flowResult) error {
+	if err := ensureDirExists(filepath.Dir(spec.Path)); err != nil {
+		return fmt.Errorf("create report directory for %q: %w", spec.Path, err)
+	}
+
+	file, err := os.Create(spec.Path)
+	if err != nil {
+		return fmt.Errorf("create %s report %q: %w", spec.Format, spec.Path, err)
+	}
+
+	switch spec.Format {
+	case reportFormatJUnit:
+		err = writeJUnitReport(file, results)
+	default:
+		err = writeJSONReport(file, results)
+	}
+	// A failed close can mean a truncated file, so it fails the report too.
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s report %q: %w", spec.Format, spec.Path, err)
+	}
+
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	File      string          `xml:"file,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+func writeJUnitReport(w io.Writer, results []flowResult) error {
+	root := junitTestSuites{Name: "go-flow"}
+
+	var total time.Duration
+	for _, flow := range results {
+		suite := junitTestSuite{
+			Name: flow.Name,
+			Time: junitSeconds(flow.Duration),
+			File: flow.Path,
+		}
+		if !flow.StartedAt.IsZero() {
+			suite.Timestamp = flow.StartedAt.UTC().Format(time.RFC3339)
+		}
+
+		stepFailed := false
+		for _, step := range flow.Steps {
+			tc := junitTestCase{
+				Name:      step.Name,
+				ClassName: flow.Name,
+				Time:      junitSeconds(step.Duration),
+				SystemOut: formatStepExchange(step),
+			}
+
+			switch step.Status {
+			case stepStatusSkipped:
+				tc.Skipped = &junitSkipped{Message: step.SkipReason}
+				suite.Skipped++
+			case stepStatusError:
+				tc.Failure = newJUnitFailure(step.Err)
+				suite.Failures++
+				stepFailed = true
+			}
+
+			suite.Cases = append(suite.Cases, tc)
+		}
+
+		// Flow-level problems (unreadable file, flows never started) have no
+		// step to attach to, so they get a synthetic testcase named after the flow.
+		switch {
+		case flow.Err != nil && !stepFailed:
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      flow.Name,
+				ClassName: flow.Name,
+				Time:      junitSeconds(flow.Duration),
+				Failure:   newJUnitFailure(flow.Err),
+			})
+			suite.Failures++
+		case flow.Status == flowStatusSkip && len(flow.Steps) == 0:
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      flow.Name,
+				ClassName: flow.Name,
+				Time:      junitSeconds(0),
+				Skipped:   &junitSkipped{Message: "flow not run"},
+			})
+			suite.Skipped++
+		}
+
+		suite.Tests = len(suite.Cases)
+
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Skipped += suite.Skipped
+		root.Suites = append(root.Suites, suite)
+		total += flow.Duration
+	}
+	root.Time = junitSeconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func newJUnitFailure(err error) *junitFailure {
+	if err == nil {
+		return &junitFailure{Type: stepStatusError}
+	}
+
+	message := err.Error()
+	firstLine, _, _ := strings.Cut(message, "\n")
+
+	return &junitFailure{
+		Message: firstLine,
+		Type:    stepStatusError,
+		Body:    message,
+	}
+}
+
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// formatStepExchange renders the captured request/response as indented JSON
+// for the testcase's system-out.
+func formatStepExchange(step stepResult) string {
+	if step.Request == nil && step.Response == nil {
+		return ""
+	}
+
+	data, err := json.MarshalIndent(map[string]any{
+		"request":  step.Request,
+		"response": step.Response,
+	}, "", "  ")
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+type jsonReport struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Passed      int              `json:"passed"`
+	Failed      int              `json:"failed"`
+	Skipped     int              `json:"skipped"`
+	Flows       []jsonReportFlow `json:"flows"`
+}
+
+type jsonReportFlow struct {
+	Name           string         `json:"name"`
+	Path           string         `json:"path"`
+	Status         string         `json:"status"`
+	StartedAt      *time.Time     `json:"started_at,omitempty"`
+	DurationMillis int64          `json:"duration_ms"`
+	Error          string         `json:"error,omitempty"`
+	Steps          []stepLogEntry `json:"steps"`
+}
+
+func writeJSONReport(w io.Writer, results []flowResult) error {
+	report := jsonReport{
+		GeneratedAt: time.Now().UTC(),
+		Flows:       make([]jsonReportFlow, 0, len(results)),
+	}
+
+	for _, flow := range results {
+		entry := jsonReportFlow{
+			Name:           flow.Name,
+			Path:           flow.Path,
+			Status:         flow.Status,
+			DurationMillis: flow.Duration.Milliseconds(),
+			Steps:          make([]stepLogEntry, 0, len(flow.Steps)),
+		}
+		if !flow.StartedAt.IsZero() {
+			startedAt := flow.StartedAt.UTC()
+			entry.StartedAt = &startedAt
+		}
+		if flow.Err != nil {
+			entry.Error = flow.Err.Error()
+		}
+		for _, step := range flow.Steps {
+			entry.Steps = append(entry.Steps, step.logEntry())
+		}
+
+		switch flow.Status {
+		case flowStatusFail:
+			report.Failed++
+		case flowStatusSkip:
+			report.Skipped++
+		default:
+			report.Passed++
+		}
+
+		report.Flows = append(report.Flows, entry)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
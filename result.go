@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+)
+
+// ResultExpectation is an inline table compared against a SQL result set.
+// Each row lists only the columns it checks, and every cell is a matcher, so
+// a plain scalar means `equals`. A bare YAML list is shorthand for `rows`.
+type ResultExpectation struct {
+	Ordered bool                 `yaml:"ordered"` // match rows by position; default is any order
+	Rows    []map[string]Matcher `yaml:"rows"`
+}
+
+func (e *ResultExpectation) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&e.Rows)
+	}
+
+	type rawExpectation ResultExpectation
+	var raw rawExpectation
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	*e = ResultExpectation(raw)
+	return nil
+}
+
+// diffResult compares the rows of a result set against the expectation and
+// returns one line per mismatch, or nil when the table matches. Column names
+// match case-insensitively, like `save`, since drivers differ in how they
+// fold unquoted identifiers.
+func diffResult(result *sqlResult, rowsJSON []byte, exp *ResultExpectation, vars map[string]string) []string {
+	columns := make(map[string]string, len(result.columns))
+	for _, column := range result.columns {
+		columns[strings.ToLower(column)] = column
+	}
+
+	if missing := missingResultColumns(columns, exp.Rows); len(missing) > 0 {
+		return []string{fmt.Sprintf("columns %s not in result (columns: %s)",
+			strings.Join(missing, ", "),
+			strings.Join(result.columns, ", "),
+		)}
+	}
+
+	actual := gjson.ParseBytes(rowsJSON).Array()
+
+	var lines []string
+	if len(actual) != len(exp.Rows) {
+		lines = append(lines, fmt.Sprintf("expected %d rows, got %d", len(exp.Rows), len(actual)))
+	}
+
+	if exp.Ordered {
+		return append(lines, diffOrderedRows(actual, exp.Rows, columns, vars)...)
+	}
+	return append(lines, diffUnorderedRows(actual, exp.Rows, columns, vars)...)
+}
+
+// missingResultColumns lists expected columns absent from the result.
+// columns maps lowercased result column names to their spelling.
+func missingResultColumns(columns map[string]string, rows []map[string]Matcher) []string {
+	seen := make(map[string]bool)
+	var missing []string
+	for _, row := range rows {
+		for column := range row {
+			if _, ok := columns[strings.ToLower(column)]; !ok && !seen[column] {
+				seen[column] = true
+				missing = append(missing, column)
+			}
+		}
+	}
+
+	sort.Strings(missing)
+	return missing
+}
+
+// rowFailures evaluates one expected row against one result row, column by
+// column in name order.
+func rowFailures(expected map[string]Matcher, row gjson.Result, columns map[string]string, vars map[string]string) []expectationFailure {
+	names := make([]string, 0, len(expected))
+	for column := range expected {
+		names = append(names, column)
+	}
+	sort.Strings(names)
+
+	var failures []expectationFailure
+	for _, column := range names {
+		value := row.Get(gjson.Escape(columns[strings.ToLower(column)]))
+		failures = append(failures, expected[column].evaluate(column, value, vars)...)
+	}
+	return failures
+}
+
+func diffOrderedRows(actual []gjson.Result, expected []map[string]Matcher, columns map[string]string, vars map[string]string) []string {
+	var lines []string
+	for idx := range max(len(actual), len(expected)) {
+		switch {
+		case idx >= len(actual):
+			lines = append(lines, fmt.Sprintf("rows[%d]: missing from result", idx))
+		case idx >= len(expected):
+			lines = append(lines, fmt.Sprintf("result[%d]: unexpected row %s", idx, trimLongString(actual[idx].Raw)))
+		default:
+			for _, failure := range rowFailures(expected[idx], actual[idx], columns, vars) {
+				lines = append(lines, fmt.Sprintf("rows[%d].%s", idx, failure.String()))
+			}
+		}
+	}
+	return lines
+}
+
+// diffUnorderedRows pairs expected rows with result rows they fully match
+// (a maximum bipartite matching, so an early loose row cannot steal the only
+// match of a later strict one). Leftover expected rows are diffed against the
+// closest leftover result row.
+func diffUnorderedRows(actual []gjson.Result, expected []map[string]Matcher, columns map[string]string, vars map[string]string) []string {
+	failures := make([][][]expectationFailure, len(expected))
+	for i, row := range expected {
+		failures[i] = make([][]expectationFailure, len(actual))
+		for j := range actual {
+			failures[i][j] = rowFailures(row, actual[j], columns, vars)
+		}
+	}
+
+	// matchedBy[j] is the expected row paired with result row j, or -1.
+	matchedBy := make([]int, len(actual))
+	for j := range matchedBy {
+		matchedBy[j] = -1
+	}
+
+	var augment func(i int, visited []bool) bool
+	augment = func(i int, visited []bool) bool {
+		for j := range actual {
+			if visited[j] || len(failures[i][j]) > 0 {
+				continue
+			}
+			visited[j] = true
+			if matchedBy[j] == -1 || augment(matchedBy[j], visited) {
+				matchedBy[j] = i
+				return true
+			}
+		}
+		return false
+	}
+
+	matched := make([]bool, len(expected))
+	for i := range expected {
+		matched[i] = augment(i, make([]bool, len(actual)))
+	}
+
+	var lines []string
+	for i := range expected {
+		if matched[i] {
+			continue
+		}
+
+		closest := -1
+		for j := range actual {
+			if matchedBy[j] != -1 {
+				continue
+			}
+			if closest == -1 || len(failures[i][j]) < len(failures[i][closest]) {
+				closest = j
+			}
+		}
+
+		if closest == -1 {
+			lines = append(lines, fmt.Sprintf("rows[%d]: missing from result", i))
+			continue
+		}
+
+		matchedBy[closest] = i
+		for _, failure := range failures[i][closest] {
+			lines = append(lines, fmt.Sprintf("rows[%d].%s (closest: result[%d])", i, failure.String(), closest))
+		}
+	}
+
+	for j, row := range actual {
+		if matchedBy[j] == -1 {
+			lines = append(lines, fmt.Sprintf("result[%d]: unexpected row %s", j, trimLongString(row.Raw)))
+		}
+	}
+
+	return lines
+}
+
+// ensureExpectedResult runs expect_result, printing the row/column diff and
+// attaching it to the step log on mismatch.
+func (r *FlowRunner) ensureExpectedResult(step Step, result *sqlResult, rowsJSON []byte, vars map[string]string, logCtx *stepLogContext) error {
+	if step.ExpectResult == nil {
+		return nil
+	}
+
+	lines := diffResult(result, rowsJSON, step.ExpectResult, vars)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if logCtx != nil {
+		logCtx.ensureResponseMap()["result_diff"] = lines
+	}
+
+	fmt.Fprintf(r.output(), "%s✖ %s: result does not match expect_result%s\n", colorRed, step.Name, colorReset)
+	for _, line := range lines {
+		fmt.Fprintf(r.output(), "   %s- %s%s\n", colorRed, line, colorReset)
+	}
+
+	return fmt.Errorf("step %q failed: result mismatch: %s", step.Name, strings.Join(lines, "; "))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryInterval = time.Second
+)
+
+// RetryPolicy re-runs a step until it succeeds (and the optional `until`
+// condition holds) or the attempt/elapsed budget runs out.
+type RetryPolicy struct {
+	Attempts   int         `yaml:"attempts"`
+	Interval   string      `yaml:"interval"`
+	Backoff    float64     `yaml:"backoff"`
+	MaxElapsed string      `yaml:"max_elapsed"`
+	Until      *RetryUntil `yaml:"until"`
+}
+
+// RetryUntil describes the result a step must produce before polling stops.
+// Status applies to HTTP, Body to any JSON result, Rows to SQL and Mongo.
+type RetryUntil struct {
+	Status int                `yaml:"status"`
+	Body   map[string]Matcher `yaml:"body"`
+	Rows   *int               `yaml:"rows"`
+}
+
+// stepOutcome captures the raw result of a single step attempt.
+type stepOutcome struct {
+	Status int
+	Body   []byte
+	Rows   int
+}
+
+func (o *stepOutcome) record(status int, body []byte, rows int) {
+	if o == nil {
+		return
+	}
+
+	o.Status = status
+	o.Body = body
+	o.Rows = rows
+}
+
+type retrySchedule struct {
+	attempts   int
+	interval   time.Duration
+	backoff    float64
+	maxElapsed time.Duration
+}
+
+func (p *RetryPolicy) schedule(vars map[string]string) (retrySchedule, error) {
+	sched := retrySchedule{
+		attempts: p.Attempts,
+		interval: defaultRetryInterval,
+		backoff:  p.Backoff,
+	}
+
+	if raw := strings.TrimSpace(render(p.Interval, vars)); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil {
+			return sched, fmt.Errorf("parse retry interval: %w", err)
+		}
+		sched.interval = interval
+	}
+
+	if raw := strings.TrimSpace(render(p.MaxElapsed, vars)); raw != "" {
+		maxElapsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return sched, fmt.Errorf("parse retry max_elapsed: %w", err)
+		}
+		sched.maxElapsed = maxElapsed
+	}
+
+	if sched.backoff < 1 {
+		sched.backoff = 1
+	}
+
+	// Without an explicit attempt count, max_elapsed alone bounds the loop.
+	if sched.attempts <= 0 && sched.maxElapsed == 0 {
+		sched.attempts = defaultRetryAttempts
+	}
+
+	return sched, nil
+}
+
+func (u *RetryUntil) unmet(outcome stepOutcome, vars map[string]string) []string {
+	if u == nil {
+		return nil
+	}
+
+	var reasons []string
+
+	if u.Status != 0 && outcome.Status != u.Status {
+		reasons = append(reasons, fmt.Sprintf("status: expected %d, got %d", u.Status, outcome.Status))
+	}
+
+	if u.Rows != nil && outcome.Rows != *u.Rows {
+		reasons = append(reasons, fmt.Sprintf("rows: expected %d, got %d", *u.Rows, outcome.Rows))
+	}
+
+	if len(u.Body) > 0 {
+		for _, failure := range evaluateExpectations(outcome.Body, u.Body, vars) {
+			reasons = append(reasons, failure.String())
+		}
+	}
+
+	return reasons
+}
+
+func (r *FlowRunner) executeWithRetry(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext) error {
+	sched, err := step.Retry.schedule(vars)
+	if err != nil {
+		return fmt.Errorf("step %q: %w", step.Name, err)
+	}
+
+	startedAt := time.Now()
+	interval := sched.interval
+
+	for attempt := 1; ; attempt++ {
+		var outcome stepOutcome
+		attemptErr := r.executeStepAttempt(ctx, step, vars, logCtx, &outcome)
+
+		reasons := step.Retry.Until.unmet(outcome, vars)
+		if attemptErr == nil && len(reasons) == 0 {
+			if logCtx != nil {
+				logCtx.ensureResponseMap()["attempts"] = attempt
+			}
+			return nil
+		}
+
+		if attemptErr == nil {
+			attemptErr = fmt.Errorf("step %q failed: retry condition not met: %s", step.Name, strings.Join(reasons, "; "))
+		}
+
+		if logCtx != nil {
+			logCtx.ensureResponseMap()["attempts"] = attempt
+		}
+
+		exhausted := sched.attempts > 0 && attempt >= sched.attempts
+		if sched.maxElapsed > 0 && time.Since(startedAt)+interval > sched.maxElapsed {
+			exhausted = true
+		}
+		if exhausted {
+			return fmt.Errorf("%w (after %d attempts)", attemptErr, attempt)
+		}
+
+		fmt.Fprintf(r.output(), "%s↻ Retrying step %q in %s (attempt %d): %v%s\n",
+			colorGray,
+			step.Name,
+			interval.String(),
+			attempt+1,
+			attemptErr,
+			colorReset,
+		)
+
+		if err := sleepContext(ctx, interval); err != nil {
+			return errors.Join(attemptErr, err)
+		}
+
+		interval = time.Duration(float64(interval) * sched.backoff)
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
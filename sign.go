@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signTypeHMAC  = "hmac"
+	signTypeSigV4 = "aws_sigv4"
+
+	defaultSignatureHeader = "X-Signature"
+	defaultTimestampHeader = "X-Timestamp"
+
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+)
+
+// SignConfig signs an HTTP request once its URL, headers, and body are fully
+// rendered, for APIs that require HMAC or AWS SigV4 signatures.
+type SignConfig struct {
+	Type string `yaml:"type"` // hmac or aws_sigv4
+
+	// hmac
+	Secret          string `yaml:"secret"`
+	Header          string `yaml:"header"`           // default X-Signature
+	TimestampHeader string `yaml:"timestamp_header"` // default X-Timestamp
+	Payload         string `yaml:"payload"`          // string-to-sign template
+	Encoding        string `yaml:"encoding"`         // hex (default) or base64
+	Prefix          string `yaml:"prefix"`           // e.g. "sha256="
+
+	// aws_sigv4
+	AccessKey    string `yaml:"access_key"`
+	SecretKey    string `yaml:"secret_key"`
+	SessionToken string `yaml:"session_token"`
+	Region       string `yaml:"region"`
+	Service      string `yaml:"service"`
+}
+
+// signRequest applies a step's sign block to req. It must run after every
+// other header (including auth) is set.
+func signRequest(req *http.Request, cfg *SignConfig, vars map[string]string, now time.Time) error {
+	if cfg == nil {
+		return nil
+	}
+
+	body, err := requestBodyBytes(req)
+	if err != nil {
+		return fmt.Errorf("read body for signing: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case signTypeHMAC:
+		return signHMAC(req, cfg, body, vars, now)
+	case signTypeSigV4:
+		return signSigV4(req, cfg, body, vars, now)
+	default:
+		return fmt.Errorf("unsupported sign type %q (use hmac or aws_sigv4)", cfg.Type)
+	}
+}
+
+// signHMAC sets a timestamp header and an HMAC-SHA256 signature over the
+// method, path (with query), timestamp, and body joined by newlines, or over
+// the rendered `payload` template when one is given.
+func signHMAC(req *http.Request, cfg *SignConfig, body []byte, vars map[string]string, now time.Time) error {
+	secret := render(cfg.Secret, vars)
+	if secret == "" {
+		return fmt.Errorf("hmac signing requires secret")
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	path := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+
+	message := strings.Join([]string{req.Method, path, timestamp, string(body)}, "\n")
+	if cfg.Payload != "" {
+		signVars := maps.Clone(vars)
+		if signVars == nil {
+			signVars = make(map[string]string)
+		}
+		signVars["method"] = req.Method
+		signVars["path"] = req.URL.EscapedPath()
+		signVars["query"] = req.URL.RawQuery
+		signVars["timestamp"] = timestamp
+		signVars["body"] = string(body)
+		rendered, err := renderExact(cfg.Payload, signVars)
+		if err != nil {
+			return fmt.Errorf("render sign payload: %w", err)
+		}
+		message = rendered
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	sum := mac.Sum(nil)
+
+	var signature string
+	switch strings.ToLower(strings.TrimSpace(cfg.Encoding)) {
+	case "", "hex":
+		signature = hex.EncodeToString(sum)
+	case "base64":
+		signature = base64.StdEncoding.EncodeToString(sum)
+	default:
+		return fmt.Errorf("unsupported signature encoding %q (use hex or base64)", cfg.Encoding)
+	}
+
+	header := signatureHeader(cfg, vars)
+	timestampHeader := strings.TrimSpace(render(cfg.TimestampHeader, vars))
+	if timestampHeader == "" {
+		timestampHeader = defaultTimestampHeader
+	}
+
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(header, render(cfg.Prefix, vars)+signature)
+	return nil
+}
+
+// signatureHeader returns the header an hmac sign block writes its signature
+// to, so logs can redact it. SigV4 signs into Authorization.
+func signatureHeader(cfg *SignConfig, vars map[string]string) string {
+	if cfg == nil || !strings.EqualFold(strings.TrimSpace(cfg.Type), signTypeHMAC) {
+		return ""
+	}
+
+	header := strings.TrimSpace(render(cfg.Header, vars))
+	if header == "" {
+		header = defaultSignatureHeader
+	}
+	return header
+}
+
+// signSigV4 implements AWS Signature Version 4 with the signature in the
+// Authorization header. It signs Host, Content-Type, and every X-Amz-* header.
+func signSigV4(req *http.Request, cfg *SignConfig, body []byte, vars map[string]string, now time.Time) error {
+	accessKey := render(cfg.AccessKey, vars)
+	secretKey := render(cfg.SecretKey, vars)
+	region := strings.TrimSpace(render(cfg.Region, vars))
+	service := strings.TrimSpace(render(cfg.Service, vars))
+	if accessKey == "" || secretKey == "" || region == "" || service == "" {
+		return fmt.Errorf("aws_sigv4 signing requires access_key, secret_key, region, and service")
+	}
+
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	date := now.Format(sigV4DateFormat)
+
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if token := render(cfg.SessionToken, vars); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	signed := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			signed[lower] = strings.Join(values, ",")
+		}
+	}
+
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(strings.Fields(signed[name]), " ") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// Every service but S3 signs the request path escaped a second time,
+	// so a %2A on the wire becomes %252A in the canonical request.
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	if service != "s3" {
+		canonicalURI = escapePathSegments(canonicalURI)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQueryString(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// escapePathSegments applies sigV4Escape to each segment of a path, keeping
+// the slashes between them.
+func escapePathSegments(path string) string {
+	segments := strings.Split(path, "/")
+	for idx, segment := range segments {
+		segments[idx] = sigV4Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// sigV4Escape percent-encodes everything except RFC 3986 unreserved characters.
+func sigV4Escape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func requestBodyBytes(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody == nil {
+		return nil, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	streamFormatSSE   = "sse"
+	streamFormatLines = "lines"
+
+	streamStopMaxEvents = "max_events reached"
+	streamStopUntil     = "until matched"
+	streamStopDeadline  = "duration elapsed"
+	streamStopClosed    = "stream closed"
+)
+
+// StreamConfig reads a streaming HTTP response incrementally instead of
+// buffering it whole. The collected events are exposed to `save` and
+// `expect` as a JSON array.
+type StreamConfig struct {
+	Format    string             `yaml:"format"`     // sse (default) or lines (e.g. NDJSON)
+	MaxEvents int                `yaml:"max_events"` // stop after this many events
+	Until     map[string]Matcher `yaml:"until"`      // stop at the first event matching every matcher
+	Duration  string             `yaml:"duration"`   // stop reading after this long
+}
+
+// streamEvent is one SSE event or line. Data holds parsed JSON when the
+// payload is valid JSON, otherwise a JSON string.
+type streamEvent struct {
+	Event string          `json:"event,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Data  json.RawMessage `json:"data"`
+}
+
+type streamResult struct {
+	events  []streamEvent
+	matched bool
+	reason  string
+}
+
+func (c *StreamConfig) format() (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(c.Format)); format {
+	case "", streamFormatSSE:
+		return streamFormatSSE, nil
+	case streamFormatLines:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported stream format %q (use sse or lines)", c.Format)
+	}
+}
+
+func (c *StreamConfig) duration(vars map[string]string) (time.Duration, error) {
+	raw := strings.TrimSpace(render(c.Duration, vars))
+	if raw == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("parse stream duration: %w", err)
+	}
+	return duration, nil
+}
+
+// readStream collects events from body until max_events, an until match,
+// the duration, or the end of the stream, whichever comes first. Hitting the
+// duration is not an error; the events read so far are returned.
+func readStream(body io.ReadCloser, cfg *StreamConfig, vars map[string]string, duration time.Duration) (streamResult, error) {
+	format, err := cfg.format()
+	if err != nil {
+		return streamResult{}, err
+	}
+
+	var expired atomic.Bool
+	if duration > 0 {
+		timer := time.AfterFunc(duration, func() {
+			expired.Store(true)
+			body.Close()
+		})
+		defer timer.Stop()
+	}
+
+	var result streamResult
+	// emit records an event and reports whether reading should stop.
+	emit := func(event streamEvent) bool {
+		result.events = append(result.events, event)
+
+		if len(cfg.Until) > 0 {
+			encoded, err := json.Marshal(event)
+			if err == nil && len(evaluateExpectations(encoded, cfg.Until, vars)) == 0 {
+				result.matched = true
+				result.reason = streamStopUntil
+				return true
+			}
+		}
+
+		if cfg.MaxEvents > 0 && len(result.events) >= cfg.MaxEvents {
+			result.reason = streamStopMaxEvents
+			return true
+		}
+		return false
+	}
+
+	var (
+		pending streamEvent
+		data    []string
+		hasData bool
+	)
+	dispatch := func() bool {
+		if !hasData {
+			pending = streamEvent{}
+			return false
+		}
+		pending.Data = streamData(strings.Join(data, "\n"))
+		stop := emit(pending)
+		pending, data, hasData = streamEvent{}, nil, false
+		return stop
+	}
+
+	reader := bufio.NewReader(body)
+	for {
+		line, readErr := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		if line != "" || readErr == nil {
+			var stop bool
+			switch format {
+			case streamFormatLines:
+				if strings.TrimSpace(line) != "" {
+					stop = emit(streamEvent{Data: streamData(line)})
+				}
+			default:
+				if line == "" {
+					stop = dispatch()
+					break
+				}
+				if strings.HasPrefix(line, ":") {
+					break
+				}
+				field, value, _ := strings.Cut(line, ":")
+				value = strings.TrimPrefix(value, " ")
+				switch field {
+				case "event":
+					pending.Event = value
+				case "id":
+					pending.ID = value
+				case "data":
+					data = append(data, value)
+					hasData = true
+				}
+			}
+			if stop {
+				return result, nil
+			}
+		}
+
+		if readErr != nil {
+			if expired.Load() {
+				result.reason = streamStopDeadline
+				return result, nil
+			}
+			if !errors.Is(readErr, io.EOF) {
+				return result, readErr
+			}
+
+			// Dispatch an event the server didn't terminate with a blank line.
+			if format == streamFormatSSE && dispatch() {
+				return result, nil
+			}
+			result.reason = streamStopClosed
+			return result, nil
+		}
+	}
+}
+
+func streamData(raw string) json.RawMessage {
+	if trimmed := strings.TrimSpace(raw); trimmed != "" && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+
+	encoded, _ := json.Marshal(raw)
+	return encoded
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	stepStatusSuccess = "success"
+	stepStatusError   = "error"
+	stepStatusSkipped = "skipped"
+)
+
+const (
+	flowStatusPass = "pass"
+	flowStatusFail = "fail"
+	flowStatusSkip = "skip"
+)
+
+type stepResult struct {
+	Name       string
+	Type       string
+	Status     string
+	SkipReason string
+	StartedAt  time.Time
+	Duration   time.Duration
+	Request    map[string]any
+	Response   map[string]any
+	Err        error
+}
+
+type flowResult struct {
+	Name      string
+	Path      string
+	Iteration string // matrix row label, empty for flows without a matrix
+	Status    string
+	StartedAt time.Time
+	Duration  time.Duration
+	Steps     []stepResult
+	Err       error
+}
+
+func (s stepResult) logEntry() stepLogEntry {
+	var errMsg string
+	if s.Err != nil {
+		errMsg = s.Err.Error()
+	}
+
+	return stepLogEntry{
+		Step:           s.Name,
+		Type:           s.Type,
+		Status:         s.Status,
+		Reason:         s.SkipReason,
+		StartedAt:      s.StartedAt.UTC(),
+		DurationMillis: s.Duration.Milliseconds(),
+		Request:        s.Request,
+		Response:       s.Response,
+		Error:          errMsg,
+	}
+}
+
+// flowStatus derives pass/fail/skip from the flow error and its steps. A flow
+// whose steps were all skipped counts as skipped rather than passed.
+func (f flowResult) flowStatus() string {
+	if f.Err != nil {
+		return flowStatusFail
+	}
+
+	if len(f.Steps) == 0 {
+		return flowStatusPass
+	}
+
+	for _, step := range f.Steps {
+		if step.Status != stepStatusSkipped {
+			return flowStatusPass
+		}
+	}
+
+	return flowStatusSkip
+}
+
+func (f flowResult) stepCounts() (passed, failed, skipped int) {
+	for _, step := range f.Steps {
+		switch step.Status {
+		case stepStatusSkipped:
+			skipped++
+		case stepStatusError:
+			failed++
+		default:
+			passed++
+		}
+	}
+
+	return passed, failed, skipped
+}
+
+func printRunSummary(w io.Writer, results []flowResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%s=== Summary ===%s\n", bold+colorCyan, colorReset)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLOW\tSTATUS\tPASSED\tFAILED\tSKIPPED\tDURATION")
+
+	var passed, failed, skipped int
+	for _, result := range results {
+		stepPassed, stepFailed, stepSkipped := result.stepCounts()
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\n",
+			result.Name,
+			strings.ToUpper(result.Status),
+			stepPassed,
+			stepFailed,
+			stepSkipped,
+			result.Duration.Round(time.Millisecond),
+		)
+
+		switch result.Status {
+		case flowStatusFail:
+			failed++
+		case flowStatusSkip:
+			skipped++
+		default:
+			passed++
+		}
+	}
+	tw.Flush()
+
+	color := colorGreen
+	if failed > 0 {
+		color = colorRed
+	}
+	fmt.Fprintf(w, "%s%d passed, %d failed, %d skipped%s\n", color, passed, failed, skipped, colorReset)
+
+	for _, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%s✖ %s: %v%s\n", colorRed, result.Name, result.Err, colorReset)
+	}
+}
+
+func summarizeFailures(results []flowResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Status == flowStatusFail {
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d flows failed", failed, len(results))
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/brianvoe/gofakeit/v7"
 	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
 )
 
 var templateFuncs = template.FuncMap{
@@ -19,6 +20,7 @@ var templateFuncs = template.FuncMap{
 	"trim":                  strings.Trim,
 	"replaceChar":           replaceCharacter,
 	"replace":               strings.ReplaceAll,
+	"jsonPath":              jsonPath,
 	"randString":            randomString,
 	"randomAddress":         randomAddress,
 	"randomCity":            randomCity,
@@ -145,3 +147,8 @@ func decodeEscapes(s string) string {
 func replaceCharacter(s, old, new string) string {
 	return strings.ReplaceAll(s, decodeEscapes(old), decodeEscapes(new))
 }
+
+// jsonPath reads a gjson path from a JSON string, e.g. {{jsonPath .item "id"}}.
+func jsonPath(data, path string) string {
+	return gjson.Get(data, path).String()
+}
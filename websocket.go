@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketCloseWait bounds how long a step waits for the server to answer
+// its close frame.
+const websocketCloseWait = time.Second
+
+// WebSocketStep connects to a WebSocket endpoint and runs a script of sends
+// and receives. Step-level `save` and `expect` see every received message as
+// a JSON array.
+type WebSocketStep struct {
+	URL          string            `yaml:"url"`
+	Headers      map[string]string `yaml:"headers"`
+	Subprotocols []string          `yaml:"subprotocols"`
+	Script       []WebSocketAction `yaml:"script"`
+}
+
+// WebSocketAction sends a text message, waits for a message matching every
+// `receive` matcher (skipping others), or both in that order.
+type WebSocketAction struct {
+	Send    string             `yaml:"send"`
+	Receive map[string]Matcher `yaml:"receive"`
+	Save    map[string]string  `yaml:"save"`    // gjson paths into the matched message
+	Timeout string             `yaml:"timeout"` // per receive; default is the step timeout
+}
+
+func (r *FlowRunner) executeWebSocketStep(ctx context.Context, step Step, vars map[string]string, logCtx *stepLogContext, outcome *stepOutcome) error {
+	cfg := step.WebSocket
+
+	target := strings.TrimSpace(render(cfg.URL, vars))
+	if target == "" {
+		return fmt.Errorf("step %q requires websocket.url", step.Name)
+	}
+	if len(cfg.Script) == 0 {
+		return fmt.Errorf("step %q requires a websocket.script", step.Name)
+	}
+
+	header := make(http.Header)
+	for k, v := range cfg.Headers {
+		header.Set(k, render(v, vars))
+	}
+
+	if r.auth != nil && !hasHeader(cfg.Headers, r.authHeaderName(vars)) {
+		name, value, err := r.authHeader(ctx, vars)
+		if err != nil {
+			return fmt.Errorf("authenticate step %q: %w", step.Name, err)
+		}
+		header.Set(name, value)
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: time.Duration(step.TimeoutSeconds) * time.Second,
+		Subprotocols:     renderStringSlice(cfg.Subprotocols, vars),
+	}
+	if r.client != nil {
+		dialer.Jar = r.client.Jar
+	}
+	if step.TLS != nil || strings.TrimSpace(step.Proxy) != "" {
+		transport, err := r.stepTransport(step, target, vars)
+		if err != nil {
+			return fmt.Errorf("configure client for step %q: %w", step.Name, err)
+		}
+		dialer.TLSClientConfig = transport.TLSClientConfig
+		dialer.Proxy = transport.Proxy
+	}
+
+	var sent, received []json.RawMessage
+	if logCtx != nil {
+		logCtx.addSensitiveHeaders(r.authHeaderName(vars))
+		reqMap := logCtx.ensureRequestMap()
+		reqMap["url"] = target
+		reqMap["headers"] = logCtx.redactHeaders(flattenHTTPHeader(header))
+		defer func() {
+			reqMap["messages"] = sent
+			logCtx.ensureResponseMap()["messages"] = received
+		}()
+	}
+
+	fmt.Fprintf(r.output(), "%s⇒ %s%s WS %s%s\n",
+		colorBlue,
+		step.Name,
+		colorReset,
+		trimLongString(target),
+		colorReset,
+	)
+
+	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(step.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	conn, resp, err := dialer.DialContext(stepCtx, target, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("connect websocket for step %q: %w (status %d)", step.Name, err, resp.StatusCode)
+		}
+		return fmt.Errorf("connect websocket for step %q: %w", step.Name, err)
+	}
+	defer conn.Close()
+
+	// Unblock pending reads when the step times out or the run is cancelled.
+	stop := context.AfterFunc(stepCtx, func() { conn.Close() })
+	defer stop()
+
+	if logCtx != nil {
+		logCtx.ensureResponseMap()["status"] = resp.StatusCode
+	}
+
+	stepDeadline, _ := stepCtx.Deadline()
+	for idx, action := range cfg.Script {
+		label := fmt.Sprintf("script[%d]", idx)
+
+		if action.Send == "" && len(action.Receive) == 0 {
+			return fmt.Errorf("step %q %s: needs send or receive", step.Name, label)
+		}
+
+		if action.Send != "" {
+			message := render(action.Send, vars)
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+				return fmt.Errorf("step %q %s: send: %w", step.Name, label, err)
+			}
+			sent = append(sent, streamData(message))
+			fmt.Fprintf(r.output(), "%s  → %s%s\n", colorGray, trimLongString(message), colorReset)
+		}
+
+		if len(action.Receive) == 0 {
+			continue
+		}
+
+		deadline := stepDeadline
+		if raw := strings.TrimSpace(render(action.Timeout, vars)); raw != "" {
+			timeout, err := time.ParseDuration(raw)
+			if err != nil {
+				return fmt.Errorf("step %q %s: parse timeout: %w", step.Name, label, err)
+			}
+			if limit := time.Now().Add(timeout); limit.Before(deadline) {
+				deadline = limit
+			}
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return fmt.Errorf("step %q %s: %w", step.Name, label, err)
+		}
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				var closeErr *websocket.CloseError
+				switch {
+				case errors.As(err, &closeErr):
+					err = fmt.Errorf("server closed the connection (%d %s)", closeErr.Code, closeErr.Text)
+				case stepCtx.Err() != nil:
+					err = stepCtx.Err()
+				}
+				fmt.Fprintf(r.output(), "%s✖ %s: no message matched %s%s\n", colorRed, step.Name, label, colorReset)
+				return fmt.Errorf("step %q failed: waiting for %s: %w", step.Name, label, err)
+			}
+
+			message := streamData(string(data))
+			received = append(received, message)
+			if len(evaluateExpectations(message, action.Receive, vars)) > 0 {
+				continue
+			}
+
+			fmt.Fprintf(r.output(), "%s  ← %s%s\n", colorGray, trimLongString(string(data)), colorReset)
+			r.saveValues(message, action.Save, vars)
+			break
+		}
+	}
+
+	closeWebSocket(conn)
+
+	respBytes, err := json.Marshal(received)
+	if err != nil {
+		return fmt.Errorf("encode websocket messages for step %q: %w", step.Name, err)
+	}
+	outcome.record(resp.StatusCode, respBytes, 0)
+
+	if err := r.validateAndSaveJSON(step, respBytes, vars, "messages"); err != nil {
+		return err
+	}
+
+	if err := r.validateExpectations(step, respBytes, vars, "messages"); err != nil {
+		return err
+	}
+
+	r.recordExport(step, vars)
+
+	fmt.Fprintf(r.output(), "%s✓ %s%s\n", colorGreen, step.Name, colorReset)
+
+	return nil
+}
+
+// closeWebSocket sends a normal close frame and waits briefly for the server
+// to acknowledge it, discarding any messages still in flight.
+func closeWebSocket(conn *websocket.Conn) {
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(websocketCloseWait)); err != nil {
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(websocketCloseWait))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}